@@ -6,6 +6,10 @@ type Streamer interface {
 	// Response to a request, to batch the response pass flush as false.
 	Response(msg Message, flush bool) error
 
+	// ResponseError to a request, to batch the response pass flush as
+	// false.
+	ResponseError(err error, flush bool) error
+
 	// Stream a single message, to batch the message pass flush as false.
 	Stream(msg Message, flush bool) error
 
@@ -14,10 +18,18 @@ type Streamer interface {
 }
 
 // BinMessage is a tuple of {id, encodedmsg-slice}. This type is used on
-// the receiver side of the transport.
+// the receiver side of the transport. Data is valid only for the
+// duration of the callback, unless retained via Retain().
 type BinMessage struct {
-	ID   uint64
-	Data []byte
+	ID    uint64
+	Data  []byte
+	lease *lease
+}
+
+// IsCancel return true if this message is a notification, to a
+// StreamCallback, that remote has cancelled the request or stream.
+func (bmsg BinMessage) IsCancel() bool {
+	return bmsg.ID == msgCancel
 }
 
 // Message interface, shall implemented by all messages exchanged via
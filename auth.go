@@ -0,0 +1,198 @@
+package gofast
+
+import "sync"
+import "time"
+import "hash"
+import "errors"
+import "sync/atomic"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+
+// Authenticator for remote node, during Handshake every node configured
+// with an Authenticator shall ask remote for a challenge and prove its
+// identity with a response. Until remote is verified, application
+// messages from remote are refused.
+type Authenticator interface {
+	// Challenge shall return a new challenge for remote.
+	Challenge(trans *Transport) ([]byte, error)
+
+	// Respond shall compute a response for challenge from remote.
+	Respond(trans *Transport, challenge []byte) ([]byte, error)
+
+	// Verify remote's response for the challenge, return nil if remote
+	// is authenticated.
+	Verify(trans *Transport, challenge, response []byte) error
+}
+
+// Authenticate remote with auth during Handshake, shall be called
+// before Handshake. Remote is expected to be configured with a
+// compatible Authenticator.
+func (t *Transport) Authenticate(auth Authenticator) *Transport {
+	t.auth = auth
+	return t
+}
+
+// authenticated shall return true if remote is verified, or if
+// transport is not configured with an Authenticator.
+func (t *Transport) authenticated() bool {
+	return t.auth == nil || atomic.LoadUint32(&t.authok) == 1
+}
+
+// authenticate local node with remote, called from Handshake.
+func (t *Transport) authenticate() error {
+	resp := &authMsg{}
+	err := t.Request(newAuth(authHello, nil), true /*flush*/, resp)
+	if err != nil {
+		return t.autherror(err)
+	} else if resp.op != authChallenge {
+		return ErrAuthFailed
+	}
+	data, err := t.auth.Respond(t, resp.data)
+	if err != nil {
+		return t.autherror(err)
+	}
+	err = t.Request(newAuth(authResponse, data), true /*flush*/, resp)
+	if err != nil {
+		return t.autherror(err)
+	} else if resp.op != authOk {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// authhandler handles authMsg from remote that is proving its identity.
+func (t *Transport) authhandler(stream *Stream, m *authMsg) {
+	var err error
+	var rv Message
+
+	switch {
+	case t.auth == nil:
+		err = errors.New("no authenticator")
+
+	case m.op == authHello:
+		var challenge []byte
+		if challenge, err = t.auth.Challenge(t); err == nil {
+			t.authchallenge.Store(challenge)
+			rv = newAuth(authChallenge, challenge)
+		}
+
+	case m.op == authResponse:
+		challenge, _ := t.authchallenge.Load().([]byte)
+		if challenge == nil {
+			err = errors.New("no challenge")
+		} else if err = t.auth.Verify(t, challenge, m.data); err == nil {
+			if atomic.CompareAndSwapUint32(&t.authok, 0, 1) {
+				atomic.AddInt64(&t.xchngok, 1)
+			}
+			rv = newAuth(authOk, nil)
+		}
+
+	default:
+		err = errors.New("unexpected auth message")
+	}
+
+	if err != nil {
+		atomic.AddUint64(&t.nAuthfail, 1)
+		warnf("%v authentication failed: %v\n", t.logprefix, err)
+		rv = &errorMsg{code: ErrorCodeAuth, text: ErrAuthFailed.Error()}
+	}
+	if err := stream.Response(rv, true /*flush*/); err != nil {
+		errorf("%v response-auth: %v\n", t.logprefix, err)
+	}
+	if rv.ID() == msgError && t.auth != nil {
+		// give remote a chance to read the error and close.
+		time.AfterFunc(authlinger, func() { t.Close() })
+	}
+}
+
+// authlinger is the time to wait before closing a transport whose remote
+// failed to authenticate.
+const authlinger = time.Second
+
+// autherror shall map errors while authenticating with remote, remote
+// might close the transport if it fails to authenticate us.
+func (t *Transport) autherror(err error) error {
+	if _, ok := err.(*RemoteError); ok {
+		return ErrAuthFailed
+	} else if t.IsClosed() {
+		return ErrAuthFailed
+	}
+	return err
+}
+
+// HMACAuth is an Authenticator based on a shared secret, challenge is a
+// random nonce and response is the HMAC-SHA256 of the nonce. To avoid
+// reflection attacks, HMACAuth shall not respond to a challenge that
+// it has issued, hence a node that both serves and dials should use
+// an HMACAuth instance for serving and another for dialing.
+type HMACAuth struct {
+	secret []byte
+	hashfn func() hash.Hash
+
+	mu     sync.Mutex
+	issued map[string]time.Time // challenges yet to be verified.
+}
+
+// hmacexpiry for challenges, that are not verified, to be forgotten.
+const hmacexpiry = time.Minute
+
+// NewHMACAuth create a new shared secret Authenticator, all nodes
+// shall use the same secret.
+func NewHMACAuth(secret []byte) *HMACAuth {
+	return &HMACAuth{
+		secret: secret,
+		hashfn: sha256.New,
+		issued: make(map[string]time.Time),
+	}
+}
+
+// Challenge implement Authenticator interface.
+func (auth *HMACAuth) Challenge(trans *Transport) ([]byte, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	auth.mu.Lock()
+	for key, at := range auth.issued {
+		if now.Sub(at) > hmacexpiry {
+			delete(auth.issued, key)
+		}
+	}
+	auth.issued[string(nonce)] = now
+	auth.mu.Unlock()
+	return nonce, nil
+}
+
+// Respond implement Authenticator interface.
+func (auth *HMACAuth) Respond(
+	trans *Transport, challenge []byte) ([]byte, error) {
+
+	auth.mu.Lock()
+	_, ok := auth.issued[string(challenge)]
+	auth.mu.Unlock()
+	if ok {
+		return nil, errors.New("challenge is reflected")
+	}
+	return auth.digest(challenge), nil
+}
+
+// Verify implement Authenticator interface.
+func (auth *HMACAuth) Verify(
+	trans *Transport, challenge, response []byte) error {
+
+	auth.mu.Lock()
+	delete(auth.issued, string(challenge))
+	auth.mu.Unlock()
+	if !hmac.Equal(auth.digest(challenge), response) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func (auth *HMACAuth) digest(challenge []byte) []byte {
+	mac := hmac.New(auth.hashfn, auth.secret)
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,115 @@
+package gofast
+
+import "testing"
+import "reflect"
+import "time"
+
+func TestAuthHMAC(t *testing.T) {
+	secret := []byte("secret")
+	addr := <-testBindAddrs
+	lis, serverch := newEchoServer(addr, NewHMACAuth(secret))
+	transc := newClient("client", addr, "").Authenticate(NewHMACAuth(secret))
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		t.Fatal(err)
+	}
+	transv := <-serverch
+
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+	if !transc.authenticated() || !transv.authenticated() {
+		t.Errorf("expected mutual authentication")
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestAuthFail(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newEchoServer(addr, NewHMACAuth([]byte("secret")))
+	transc := newClient("client", addr, "")
+	transc.Authenticate(NewHMACAuth([]byte("guess")))
+	if err := transc.Handshake(); err != ErrAuthFailed {
+		t.Errorf("expected %v, got %v", ErrAuthFailed, err)
+	}
+	select {
+	case <-serverch:
+		t.Errorf("unexpected handshake on server")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if !transc.IsClosed() {
+		t.Errorf("expected transport to be closed")
+	}
+	lis.Close()
+}
+
+func TestAuthRefuse(t *testing.T) {
+	secret := []byte("secret")
+	addr := <-testBindAddrs
+	lis, serverch := newEchoServer(addr, NewHMACAuth(secret))
+	auth := &testAuth{NewHMACAuth(secret), make(chan struct{})}
+	transc := newClient("client", addr, "").Authenticate(auth)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	errch := make(chan error, 1)
+	go func() { errch <- transc.Handshake() }()
+	time.Sleep(100 * time.Millisecond)
+
+	// client is yet to respond to server's challenge.
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != ErrAuthFailed {
+		t.Errorf("expected %v, got %v", ErrAuthFailed, err)
+	}
+
+	close(auth.respch)
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestHMACReflect(t *testing.T) {
+	auth := NewHMACAuth([]byte("secret"))
+	challenge, err := auth.Challenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := auth.Respond(nil, challenge); err == nil {
+		t.Errorf("expected error for reflected challenge")
+	}
+
+	peer := NewHMACAuth([]byte("secret"))
+	if response, err := peer.Respond(nil, challenge); err != nil {
+		t.Error(err)
+	} else if err := auth.Verify(nil, challenge, response); err != nil {
+		t.Error(err)
+	}
+	if len(auth.issued) != 0 {
+		t.Errorf("expected verified challenge to be forgotten")
+	}
+}
+
+type testAuth struct {
+	*HMACAuth
+	respch chan struct{}
+}
+
+func (auth *testAuth) Respond(
+	trans *Transport, challenge []byte) ([]byte, error) {
+
+	<-auth.respch
+	return auth.HMACAuth.Respond(trans, challenge)
+}
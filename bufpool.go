@@ -0,0 +1,65 @@
+package gofast
+
+// sizeclasses for pooled buffers, buffers larger than buffersize are
+// allocated on demand and left to GC.
+var sizeclasses = []int{512, 4 * 1024, 64 * 1024, 1024 * 1024}
+
+// poolbudget is the approximate number of bytes held by each size class
+// while idle.
+const poolbudget = 1024 * 1024
+
+type bufpool struct {
+	classes []int
+	pools   []chan []byte
+}
+
+// newbufpool with size classes upto maxsize, maxsize is added as the
+// largest class if it does not fall on a class boundary.
+func newbufpool(maxsize int) *bufpool {
+	p := &bufpool{}
+	for _, class := range sizeclasses {
+		if class >= maxsize {
+			break
+		}
+		p.classes = append(p.classes, class)
+	}
+	p.classes = append(p.classes, maxsize)
+	for _, class := range p.classes {
+		n := poolbudget / class
+		if n < 2 {
+			n = 2
+		}
+		p.pools = append(p.pools, make(chan []byte, n))
+	}
+	return p
+}
+
+// get a buffer of length size, from the smallest class that can hold it.
+func (p *bufpool) get(size int) []byte {
+	for i, class := range p.classes {
+		if size > class {
+			continue
+		}
+		select {
+		case buf := <-p.pools[i]:
+			return buf[:size]
+		default:
+			return make([]byte, size, class)
+		}
+	}
+	return make([]byte, size)
+}
+
+// put buffer back to its class, buffers not obtained from get() are
+// left to GC.
+func (p *bufpool) put(buf []byte) {
+	for i, class := range p.classes {
+		if cap(buf) == class {
+			select {
+			case p.pools[i] <- buf[:class]:
+			default: // let GC collect the buffer
+			}
+			return
+		}
+	}
+}
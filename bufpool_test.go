@@ -0,0 +1,46 @@
+package gofast
+
+import "reflect"
+import "testing"
+
+func TestBufpool(t *testing.T) {
+	p := newbufpool(512)
+	if ref := []int{512}; !reflect.DeepEqual(p.classes, ref) {
+		t.Errorf("expected %v, got %v", ref, p.classes)
+	}
+	p = newbufpool(100 * 1024)
+	if ref := []int{512, 4096, 65536, 102400}; !reflect.DeepEqual(p.classes, ref) {
+		t.Errorf("expected %v, got %v", ref, p.classes)
+	}
+
+	testcases := [][2]int{ // size, capacity
+		{0, 512}, {10, 512}, {512, 512}, {513, 4096}, {4096, 4096},
+		{5000, 65536}, {65537, 102400}, {102400, 102400}, {102401, 102401},
+	}
+	for _, tc := range testcases {
+		buf := p.get(tc[0])
+		if len(buf) != tc[0] || cap(buf) != tc[1] {
+			t.Errorf("%v: unexpected %v %v", tc, len(buf), cap(buf))
+		}
+		p.put(buf)
+	}
+	// reuse
+	buf := p.get(1000)
+	buf[0] = 0xAB
+	p.put(buf)
+	if buf = p.get(2000); buf[0] != 0xAB {
+		t.Errorf("expected pooled buffer")
+	}
+	// foreign buffers are left to GC.
+	p.put(make([]byte, 1000))
+	if n := len(p.pools[1]); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+}
+
+func BenchmarkBufpool(b *testing.B) {
+	p := newbufpool(1024 * 1024)
+	for i := 0; i < b.N; i++ {
+		p.put(p.get(1000))
+	}
+}
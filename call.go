@@ -0,0 +1,152 @@
+package gofast
+
+import "fmt"
+import "context"
+import "sync/atomic"
+
+// Call represents an active request started via Transport.Go().
+type Call struct {
+	Request  Message    // request message sent to remote.
+	Response Message    // response message decoded from remote.
+	Error    error      // after completion, the error status.
+	Done     chan *Call // receives *Call when Go is complete.
+}
+
+// Go request a response from peer asynchronously. It returns the Call
+// structure representing the invocation. The done channel will signal
+// when the call is complete by returning the same Call object. If done
+// is nil, Go will allocate a new channel. If non-nil, done must be
+// buffered or Go will deliberately panic. If "request.timeout" is
+// configured, call shall complete with context.DeadlineExceeded after
+// that period.
+//
+// Unlike Request(), Go does not block the caller on the response, nor
+// on writing the request to socket, hence a single routine can keep
+// several requests in flight on the same transport. Go shall block only
+// while waiting for a free opaque, use GoContext() to bound that wait.
+func (t *Transport) Go(msg Message, resp Message, done chan *Call) *Call {
+	return t.GoContext(context.Background(), msg, resp, done)
+}
+
+// GoContext is same as Go, but the call shall complete with ctx.Err()
+// if ctx is done before a free opaque is available, or before the
+// response. Late response, if any, from remote shall be dropped.
+func (t *Transport) GoContext(
+	ctx context.Context, msg Message, resp Message, done chan *Call) *Call {
+
+	if done == nil {
+		done = make(chan *Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		panic(fmt.Errorf("%v Go(): done channel is unbuffered", t.logprefix))
+	}
+	call := &Call{Request: msg, Response: resp, Done: done}
+	if call.Error = ctx.Err(); call.Error != nil {
+		call.done()
+		return call
+	} else if call.Error = t.goingaway(msg); call.Error != nil {
+		call.done()
+		return call
+	} else if call.Error = t.oversized(msg); call.Error != nil {
+		call.done()
+		return call
+	}
+
+	// cancel shall release the routine watching ctx, once responded.
+	cancel := context.CancelFunc(func() {})
+	if t.reqtimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.reqtimeout)
+	} else if ctx.Done() != nil {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	fail := func(err error) *Call {
+		cancel()
+		atomic.AddInt64(&t.nflight, -1)
+		call.Error = err
+		call.done()
+		return call
+	}
+
+	atomic.AddInt64(&t.nflight, 1)
+	var state int32 // 0 waiting, 1 responded, 2 cancelled.
+	lane := t.msglane(msg)
+	stream, err := t.getlocalstream(ctx, lane, false, func(
+		bmsg BinMessage, ok bool) {
+
+		if atomic.CompareAndSwapInt32(&state, 0, 1) {
+			cancel()
+			atomic.AddInt64(&t.nflight, -1)
+			call.Error = rxresponse(bmsg, resp)
+			call.done()
+		}
+	})
+	if err != nil {
+		return fail(err)
+	}
+	stream.oneshot = true
+	t.putch(t.rxch, rxpacket{stream: stream})
+	gen := atomic.LoadUint64(&stream.gen)
+
+	// queue the request, it is flushed as soon as doTx is idle.
+	locked := t.txlock()
+	err = t.txmsg(t.request, msg, stream, t.txasync, false /*flush*/)
+	t.txunlock(locked)
+	if err != nil {
+		t.dropstream(stream)
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			return fail(err)
+		}
+		return call // transport closed, and callback has completed call.
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-t.killch:
+				return // syncRx shall complete the call.
+			}
+			cancel()
+			if atomic.CompareAndSwapInt32(&state, 0, 2) {
+				atomic.AddInt64(&t.nflight, -1)
+				t.cancelstream(stream, gen, true /*request*/)
+				call.Error = ctx.Err()
+				call.done()
+			}
+		}()
+	}
+	return call
+}
+
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+	default:
+		// don't block here, it is the caller's responsibility to make
+		// sure the channel has enough buffer space.
+		warnf("Call.done(): discarding Call reply due to insufficient Done chan capacity\n")
+	}
+}
+
+// rxresponse for a local request, decode into resp or return remote
+// error.
+func rxresponse(bmsg BinMessage, resp Message) error {
+	if bmsg.ID == 0 { // transport closed, or junk response.
+		return fmt.Errorf("transport closed")
+	} else if bmsg.ID == msgError {
+		var m errorMsg
+		if _, err := m.decode(bmsg.Data); err != nil {
+			return err
+		} else if m.code == ErrorCodeGoaway {
+			return ErrGoaway
+		} else if m.code == ErrorCodeAuth {
+			return ErrAuthFailed
+		}
+		return m.toerror()
+	} else if m, ok := resp.(decoder); ok {
+		_, err := m.decode(bmsg.Data)
+		return err
+	} else if resp != nil {
+		resp.Decode(bmsg.Data)
+	}
+	return nil
+}
@@ -53,18 +53,21 @@ const ( // pre-defined tag values
 	tagStream
 	// tag 8 (unassigned as per spec). says frame carries stream FINISH.
 	tagFinish
+	// tag 9 (unassigned as per spec). says frame carries CANCEL for an
+	// on-going request or stream.
+	tagCancel
 
-	// unassigned 9..20
+	// unassigned 10..20
 
 	// TODO: tagBase64URL, tagBase64, tagBase16
-	tagBase64URL = iota + 12 // interpret []byte as base64 format
+	tagBase64URL = iota + 11 // interpret []byte as base64 format
 	tagBase64                // interpret []byte as base64 format
 	tagBase16                // interpret []byte as base16 format
 	tagCborEnc               // embedd another CBOR message
 
 	// unassigned 25..31
 
-	tagURI          = iota + 19 // defined in rfc3986
+	tagURI          = iota + 18 // defined in rfc3986
 	tagBase64URLEnc             // base64 encoded url as text strings
 	tagBase64Enc                // base64 encoded byte-string as text strings
 	tagRegexp                   // PCRE and ECMA262 regular expression
@@ -72,7 +75,7 @@ const ( // pre-defined tag values
 
 	// tag 43 (unassigned as per spec). says payload is encoded message
 	// that shall be passed on to the subscribed handler.
-	tagMsg = iota + 25
+	tagMsg = iota + 24
 	// tag 44 (unassigned as per spec). place-holder for "id" header key.
 	tagID
 	// tag 45 (unassigned as per spec). place-holder for "data" header key.
@@ -83,6 +86,21 @@ const ( // pre-defined tag values
 	// tag 47 (unassinged as per spec). says payload is compressed using
 	// Lzw compression method.
 	tagLzw
+	// tag 48 (unassinged as per spec). says payload is suffixed with
+	// CRC32C checksum.
+	tagCrc32c
+	// tag 49 (unassinged as per spec). says payload is suffixed with
+	// HMAC-SHA256 digest.
+	tagHMAC
+	// tag 50 (unassinged as per spec). says payload is encrypted using
+	// AES-GCM.
+	tagAESGCM
+	// tag 51 (unassinged as per spec). says payload is compressed using
+	// deflate, with dictionary carried over from previous packets.
+	tagGzipStream
+	// tag 52 (unassigned as per spec). place-holder for "more" header
+	// key, carries the total length of a fragmented message.
+	tagMore
 
 	tagCborPrefix = 55799
 )
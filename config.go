@@ -5,16 +5,47 @@ import s "github.com/bnclabs/gosettings"
 
 /*
 DefaultSettings for gofast, start and end arguments are used to generate
-opaque id for streams.
+opaque id for streams. Parameters missing from settings supplied to
+NewTransport(), and other APIs accepting settings, default to these.
 
 Configurable parameters:
 
 "buffersize" (int64, default: 512)
-   Maximum size that a single message will need for encoding.
+   Maximum size of a single packet. Messages that need more than this
+   for encoding are split into continuation frames and reassembled by
+   remote.
+
+"maxmessagesize" (int64, default: 16777216)
+   Maximum size of a message. Post, Request and Stream shall fail with
+   ErrMessageTooLarge if Message.Size() exceeds this, and larger
+   messages received from remote are dropped.
+
+"reassembly.limit" (int64, default: 67108864)
+   Maximum bytes of fragmented messages being reassembled at a time,
+   across all streams on the transport. Messages received beyond this
+   limit are dropped and counted as "n_corrupt".
+
+"lease.debug" (bool, default: false)
+   If true, message buffers released by handlers, or by transport after
+   the callback, are poisoned and kept out of the pool to catch use of
+   BinMessage.Data after release.
 
 "batchsize" (int64, default:1 )
    Number of messages to batch before writing to socket, transport
-   will create a local buffer of size buffersize * batchsize.
+   will create a local buffer of size buffersize * batchsize, unless
+   batches are written using vectored writes. Batch is flushed when it
+   is full, when a packet is queued with flush, or when there are no
+   more packets queued for transmission.
+
+"batch.linger" (int64, default: 0)
+   Microseconds the oldest packet in a batch may wait for the batch to
+   fill while packets keep getting queued. ZERO means no limit other
+   than batchsize. Use FlushPeriod() to also flush a batch left idle.
+
+"tx.writev" (bool, default: true)
+   Write batched packets using vectored writes, if connection is a
+   *net.TCPConn or implements BuffersWriter, avoiding a copy of every
+   packet into the local buffer.
 
 "chansize" (int64, default: 100000)
    Buffered channel size to use for internal go-routines.
@@ -26,19 +57,145 @@ Configurable parameters:
    Ending opaque range, inclusive.
 
 "tags" (int64, default: "")
-   Comma separated list of tags to apply, in specified order.
+   Comma separated list of supported tags, in preferred order. The list
+   is advertised to remote during handshake, tags supported by both
+   sides are applied by remote in this order, first tag on the message
+   and last tag on the outermost payload, and this side shall unwrap
+   them in reverse.
+
+"tags.required" (string, default: "")
+   Comma separated list of tags, from "tags", that remote must support,
+   else Handshake shall fail with ErrTagMismatch. Incoming packets
+   without the required tags are dropped as corrupt. Integrity tags,
+   "hmac" and "aesgcm", are implicitly required once negotiated. List
+   them here to prevent a man in the middle from stripping them off
+   the tags advertised during handshake.
 
 "gzip.level" (int64, default: <flate.BestSpeed>)
-   Gzip compression level, if `tags` contain "gzip".
+   Gzip compression level, if `tags` contain "gzip" or "gzipstream".
+   Tag "gzipstream" is stateful, compression dictionary is carried
+   over from one packet to the next on the same connection, and
+   packets are transmitted in the order they are compressed.
+
+"hmac.key" (string, default: "")
+   Shared secret for HMAC-SHA256 digest, if `tags` contain "hmac".
+   Both nodes shall be configured with the same key. Whoami exchanged
+   during handshake is also authenticated with this key, Handshake
+   shall fail with ErrAuthFailed if it was tampered with.
+
+"aesgcm.keys" (string, default: "")
+   Pre-shared keys for AES-GCM encryption, if `tags` contain "aesgcm".
+   Comma separated list of <keyid>:<hex-key>, keyid is within [0,255]
+   and key is 16, 24 or 32 bytes, hex encoded. Packets are encrypted
+   with a subkey derived from the pre-shared key and salts exchanged
+   during handshake. Tag "aesgcm" is stateful, packets are transmitted
+   in the order they are encrypted, and replayed packets are dropped.
+
+"aesgcm.keyid" (int64, default: 0)
+   Key, from "aesgcm.keys", to use for encrypting packets. Incoming
+   packets can be encrypted with any of the configured keys, to rotate
+   keys add the new key on all nodes and then switch "aesgcm.keyid".
+
+"corrupt.close" (bool, default: false)
+   If true, close the transport when a packet fails the integrity
+   check, like "crc32c" or "hmac" tags, or fails decryption with
+   "aesgcm" tag, else the packet is dropped.
+
+"request.timeout" (int64, default: 0)
+   Timeout in milliseconds for Request() calls that are not supplied
+   with a context, ZERO means wait for ever.
+
+"stream.window" (int64, default: 0)
+   Number of messages remote can stream on a single stream before
+   waiting for more credits from this side, advertised to remote
+   during handshake. ZERO disables flow control.
+
+"stream.block" (bool, default: true)
+   If true, Stream.Stream() shall block till remote grants more
+   credits, else return ErrWindowExhausted.
+
+"stream.autogrant" (bool, default: true)
+   If true, credits are granted back to remote as and when incoming
+   stream messages are handled by StreamCallback, else application
+   shall call Stream.Grant() after consuming the messages.
+
+"dispatch.workers" (int64, default: 0)
+   Number of routines to dispatch RequestCallback and StreamCallback,
+   callbacks for the same stream are always dispatched in order by the
+   same routine. ZERO dispatches them inline from the receive routine.
+
+"server.maxconns" (int64, default: 0)
+   Used by Server, maximum number of live transports, once reached
+   Server shall stop accepting connections till a transport is closed.
+   ZERO means no limit.
+
+"server.handshaketimeout" (int64, default: 10000)
+   Used by Server, milliseconds to wait for a new connection to complete
+   its TLS handshake and transport handshake, after which connection is
+   closed. ZERO means no limit.
+
+"pool.size" (int64, default: 4)
+   Used by Pool, number of transports to maintain.
+
+"pool.policy" (string, default: "roundrobin")
+   Used by Pool to pick a transport for every call, can be
+   "roundrobin", "leastoutstanding" or "twochoices". "leastoutstanding"
+   pick the transport with least requests waiting for response,
+   "twochoices" pick two transports at random and use the lesser loaded.
+
+"reconnect.policy" (string, default: "fail")
+   Used by ReconnectingTransport, while disconnected "fail" shall fail
+   the calls with ErrDisconnected, "queue" shall wait for connection.
+
+"reconnect.minbackoff" (int64, default: 100)
+   Used by ReconnectingTransport, milliseconds to wait before re-dialing,
+   doubled on every failed attempt.
+
+"reconnect.maxbackoff" (int64, default: 10000)
+   Used by ReconnectingTransport, maximum milliseconds to wait before
+   re-dialing.
+
+"reconnect.handshaketimeout" (int64, default: 10000)
+   Used by ReconnectingTransport, milliseconds to wait for a new
+   connection to complete the transport handshake, after which
+   connection is closed and re-dialed. ZERO means no limit.
 */
 func DefaultSettings(start, end int64) s.Settings {
 	return s.Settings{
-		"buffersize":   512,
-		"batchsize":    1,
-		"chansize":     100000,
-		"tags":         "",
-		"opaque.start": start,
-		"opaque.end":   end,
-		"gzip.level":   flate.BestSpeed,
+		"buffersize":       512,
+		"maxmessagesize":   16 * 1024 * 1024,
+		"reassembly.limit": 64 * 1024 * 1024,
+		"lease.debug":      false,
+		"batchsize":        1,
+		"batch.linger":     0,
+		"tx.writev":        true,
+		"chansize":         100000,
+		"tags":             "",
+		"opaque.start":     start,
+		"opaque.end":       end,
+		"gzip.level":       flate.BestSpeed,
+		"hmac.key":         "",
+		"aesgcm.keys":      "",
+		"aesgcm.keyid":     0,
+
+		"tags.required": "",
+		"corrupt.close": false,
+
+		"request.timeout":  0,
+		"stream.window":    0,
+		"stream.block":     true,
+		"stream.autogrant": true,
+		"dispatch.workers": 0,
+
+		"server.maxconns":         0,
+		"server.handshaketimeout": 10000,
+
+		"pool.size":   4,
+		"pool.policy": "roundrobin",
+
+		"reconnect.policy":           "fail",
+		"reconnect.minbackoff":       100,
+		"reconnect.maxbackoff":       10000,
+		"reconnect.handshaketimeout": 10000,
 	}
 }
@@ -4,3 +4,48 @@ import "errors"
 
 // ErrorInvalidTag if supplied tag is not supported by the gofast package.
 var ErrorInvalidTag = errors.New("gofast.invalidtag")
+
+// ErrStreamCancelled if remote has cancelled the request or stream, and
+// application attempts to respond or stream on it.
+var ErrStreamCancelled = errors.New("gofast.streamcancelled")
+
+// ErrWindowExhausted if remote has not granted credits to stream more
+// messages, and "stream.block" is configured as false.
+var ErrWindowExhausted = errors.New("gofast.windowexhausted")
+
+// ErrGoaway if transport is shutting down, or remote has asked to go
+// away, and application attempts to start a new post, request or stream.
+var ErrGoaway = errors.New("gofast.goaway")
+
+// ErrDisconnected if ReconnectingTransport is yet to connect with remote,
+// and "reconnect.policy" is "fail".
+var ErrDisconnected = errors.New("gofast.disconnected")
+
+// ErrServerClosed returned by Server.Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("gofast.serverclosed")
+
+// ErrAuthFailed if remote failed to authenticate, or if local node is yet
+// to be authenticated by remote.
+var ErrAuthFailed = errors.New("gofast.authfailed")
+
+// ErrTagMismatch if a tag required by either side is not supported by
+// the other side.
+var ErrTagMismatch = errors.New("gofast.tagmismatch")
+
+// ErrTagReserved if a custom tag's id falls within the range reserved by
+// CBOR and gofast, or overlaps with opaque-space.
+var ErrTagReserved = errors.New("gofast.tagreserved")
+
+// ErrTagEncode if a negotiated tag fails to encode a packet, integrity
+// tags are never skipped.
+var ErrTagEncode = errors.New("gofast.tagencode")
+
+// ErrTagDuplicate if a custom tag's name or id is already registered.
+var ErrTagDuplicate = errors.New("gofast.tagduplicate")
+
+// ErrMessageTooLarge if message's Size() exceeds "maxmessagesize".
+var ErrMessageTooLarge = errors.New("gofast.messagetoolarge")
+
+// ErrMalformedMessage if a predefined message received from remote is
+// truncated, or its lengths run past the end of the message.
+var ErrMalformedMessage = errors.New("gofast.malformedmessage")
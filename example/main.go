@@ -34,6 +34,7 @@ var options struct {
 	buffersize int
 	batchsize  int
 	flushtick  time.Duration
+	linger     int
 
 	// server specific options.
 	//
@@ -64,6 +65,8 @@ func argParse() {
 		"no. of messages to batch")
 	flag.IntVar(&flushtick, "flushtick", 10,
 		"flush period in milliseconds.")
+	flag.IntVar(&options.linger, "linger", 0,
+		"microseconds oldest packet may wait in a batch")
 	flag.StringVar(&options.log, "log", "error",
 		"log level")
 
@@ -35,39 +35,26 @@ func runserver(lis net.Listener) {
 	config := newconfig(1000, opqend)
 	config["tags"] = options.tags
 	config["batchsize"] = options.batchsize
-	conncount := 0
-	for {
-		if conn, err := lis.Accept(); err == nil {
-			name := fmt.Sprintf("server-%v", conncount)
-			conncount += 1
-			fmt.Println("new transport", conn.RemoteAddr(), conn.LocalAddr())
-			trans, err := gf.NewTransport(name, conn, &ver, config)
-			if err != nil {
-				panic("NewTransport server failed")
-			}
+
+	mux := gf.NewMux()
+	mux.SubscribeMessage(
+		&msgPost{},
+		func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
+			// Fill up your handler code here.
+			return nil
+		})
+	srv := &gf.Server{
+		Name: "server", Settings: config, Version: &ver, Mux: mux,
+		Setup: func(trans *gf.Transport) {
+			fmt.Println("new transport", trans.RemoteAddr(), trans.LocalAddr())
 			mu.Lock()
 			transs = append(transs, trans)
 			mu.Unlock()
-			go func(trans *gf.Transport) {
-				trans.FlushPeriod(options.flushtick * time.Millisecond)
-				trans.SendHeartbeat(1 * time.Second)
-				trans.SubscribeMessage(
-					&msgPost{},
-					func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
-						// Fill up your handler code here.
-						return nil
-					})
-				if err := trans.Handshake(); err != nil {
-					panic(err)
-				}
-				//tick := time.Tick(1 * time.Second)
-				//for {
-				//	<-tick
-				//	if options.log == "debug" {
-				//		printCounts(trans.Stat())
-				//	}
-				//}
-			}(trans)
-		}
+			trans.FlushPeriod(options.flushtick * time.Millisecond)
+			trans.SendHeartbeat(1 * time.Second)
+		},
+	}
+	if err := srv.Serve(lis); err != nil {
+		log.Println(err)
 	}
 }
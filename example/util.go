@@ -66,5 +66,6 @@ func newconfig(start, end int) map[string]interface{} {
 		"opaque.start": start,
 		"opaque.end":   end,
 		"gzip.level":   flate.BestSpeed,
+		"batch.linger": options.linger,
 	}
 }
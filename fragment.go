@@ -0,0 +1,203 @@
+package gofast
+
+import "fmt"
+import "sync/atomic"
+
+// Messages whose encoding does not fit within a single packet are
+// split into continuation frames on the same opaque, every frame except
+// the last carry the total length of the message under the "more"
+// header key. Remote shall reassemble the frames before dispatching
+// the message.
+//
+//   | tagMsg | {tagID: id, tagData: chunk, tagMore: total} |
+//   ...
+//   | tagMsg | {tagID: id, tagData: chunk} |
+
+// fixed framing overhead per packet, and overhead per tag layer.
+const fragOverhead, fragTagOverhead = 128, 64
+
+// fragsize is the maximum bytes of encoded message, to be carried in
+// a single packet.
+func fragsize(buffersize uint64, ntags int) int64 {
+	return int64(buffersize) - fragOverhead - int64(ntags*fragTagOverhead)
+}
+
+// oversized return ErrMessageTooLarge if msg is larger than
+// "maxmessagesize", shall be checked before encoding the message.
+func (t *Transport) oversized(msg Message) error {
+	if uint64(msg.Size()) > t.maxmsgsize {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+type framefn func(msg Message, stream *Stream, out []byte) (int, error)
+
+type txfn func(out []byte, lane Lane, flush bool) error
+
+// txmsg frame and transmit msg using txfn on stream's lane, split the
+// message into continuation frames if it is larger than a packet.
+func (t *Transport) txmsg(
+	frame framefn, msg Message, stream *Stream, tx txfn, flush bool) error {
+
+	msg, lane := unlane(msg), stream.lane
+	overhead := int64(t.buffersize) - t.fragsize
+	if msg.Size() <= t.fragsize {
+		out := t.bufs.get(int(msg.Size() + overhead))
+		defer t.bufs.put(out)
+		out = out[:cap(out)]
+		n, err := frame(msg, stream, out)
+		if err != nil {
+			return err
+		}
+		return tx(out[:n], lane, flush)
+	}
+
+	buf := t.getdata(int(msg.Size()))
+	defer t.putdata(buf)
+	data := msg.Encode(buf)
+	out := t.bufs.get(int(t.buffersize))
+	defer t.bufs.put(out)
+
+	frag := &fragMsg{id: msg.ID(), data: data}
+	for {
+		frag.end = frag.off + int(t.fragsize)
+		if frag.end > len(data) {
+			frag.end = len(data)
+		}
+		n, err := frame(frag, stream, out)
+		if err != nil {
+			return err
+		} else if !frag.more() {
+			return tx(out[:n], lane, flush)
+		} else if err := t.txasync(out[:n], lane, false); err != nil {
+			return err
+		}
+		frag.off = frag.end
+	}
+}
+
+// fragMsg is a chunk, data[off:end], of an encoded message.
+type fragMsg struct {
+	id       uint64
+	data     []byte
+	off, end int
+}
+
+func (msg *fragMsg) ID() uint64 {
+	return msg.id
+}
+
+func (msg *fragMsg) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	n := copy(out, msg.data[msg.off:msg.end])
+	return out[:n]
+}
+
+func (msg *fragMsg) Decode(in []byte) int64 {
+	panic("fragMsg.Decode(): not expected")
+}
+
+func (msg *fragMsg) Size() int64 {
+	return int64(msg.end - msg.off)
+}
+
+func (msg *fragMsg) String() string {
+	return fmt.Sprintf("fragMsg:%v[%v:%v]", msg.id, msg.off, msg.end)
+}
+
+// more return true if there are more frames to follow.
+func (msg *fragMsg) more() bool {
+	return msg.end < len(msg.data)
+}
+
+type rxfrag struct {
+	id       uint64
+	total    uint64
+	reserved uint64 // bytes reserved against "reassembly.limit"
+	data     []byte
+	drop     bool
+}
+
+// rxfrags track partial messages by opaque, owned by doRx routine.
+type rxfrags struct {
+	frags map[uint64]*rxfrag
+	size  uint64 // total bytes reserved by partial messages.
+}
+
+func newrxfrags() *rxfrags {
+	return &rxfrags{frags: make(map[uint64]*rxfrag)}
+}
+
+func (rf *rxfrags) remove(opaque uint64, frag *rxfrag) {
+	if frag != nil {
+		rf.size -= frag.reserved
+		delete(rf.frags, opaque)
+	}
+}
+
+// reassemble continuation frames of a fragmented message, return false
+// till the last frame is received, or if the message is dropped.
+// Continuation frames for a different message id, or beyond the
+// announced total, and messages exceeding "reassembly.limit" are
+// counted as "n_corrupt".
+func (t *Transport) reassemble(rf *rxfrags, rxpkt rxpacket) (rxpacket, bool) {
+	frag, ok := rf.frags[rxpkt.opaque]
+	if !ok && rxpkt.more == 0 { // not fragmented
+		return rxpkt, true
+	} else if rxpkt.finish { // stream ended, before the last frame.
+		rf.remove(rxpkt.opaque, frag)
+		return rxpkt, true
+	}
+
+	data := rxpkt.msg.Data
+	if !ok { // first frame
+		frag = &rxfrag{id: rxpkt.msg.ID, total: rxpkt.more}
+		if frag.total > t.maxmsgsize {
+			fmsg := "%v ##%v message size %v exceeds maxmessagesize\n"
+			warnf(fmsg, t.logprefix, rxpkt.opaque, frag.total)
+			frag.drop = true
+		} else if rf.size+frag.total > t.reassembly {
+			fmsg := "%v ##%v message size %v exceeds reassembly.limit\n"
+			warnf(fmsg, t.logprefix, rxpkt.opaque, frag.total)
+			atomic.AddUint64(&t.nCorrupt, 1)
+			frag.drop = true
+		} else {
+			frag.reserved = frag.total
+			rf.size += frag.reserved
+		}
+		rf.frags[rxpkt.opaque] = frag
+	} else {
+		atomic.AddUint64(&t.nRxfrag, 1)
+	}
+	if rxpkt.msg.ID == 0 {
+		frag.drop = true
+	} else if frag.drop == false {
+		if rxpkt.msg.ID != frag.id {
+			fmsg := "%v ##%v continuation frame for message %v, expected %v\n"
+			warnf(fmsg, t.logprefix, rxpkt.opaque, rxpkt.msg.ID, frag.id)
+			atomic.AddUint64(&t.nCorrupt, 1)
+			frag.drop = true
+		} else if uint64(len(frag.data)+len(data)) > frag.total {
+			fmsg := "%v ##%v continuation frame exceeds message size %v\n"
+			warnf(fmsg, t.logprefix, rxpkt.opaque, frag.total)
+			atomic.AddUint64(&t.nCorrupt, 1)
+			frag.drop = true
+		} else {
+			frag.data = append(frag.data, data...)
+		}
+	}
+	t.putdata(data)
+
+	if rxpkt.more > 0 {
+		return rxpkt, false
+	}
+	rf.remove(rxpkt.opaque, frag)
+	if frag.drop || uint64(len(frag.data)) != frag.total {
+		warnf("%v ##%v dropping fragmented message\n", t.logprefix, rxpkt.opaque)
+		atomic.AddUint64(&t.nMdrops, 1)
+		return rxpkt, false
+	}
+	rxpkt.msg.Data = frag.data
+	return rxpkt, true
+}
@@ -0,0 +1,289 @@
+package gofast
+
+import "bytes"
+import "testing"
+import "time"
+
+const msgBlob = msgLarge + 1
+
+type blobMessage struct {
+	data []byte
+}
+
+func (msg *blobMessage) ID() uint64 {
+	return msgBlob
+}
+
+func (msg *blobMessage) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	n := copy(out, msg.data)
+	return out[:n]
+}
+
+func (msg *blobMessage) Decode(in []byte) int64 {
+	msg.data = append(msg.data[:0], in...)
+	return int64(len(in))
+}
+
+func (msg *blobMessage) Size() int64 {
+	return int64(len(msg.data))
+}
+
+func (msg *blobMessage) String() string {
+	return "blobMessage"
+}
+
+func newblob(size int) *blobMessage {
+	msg := &blobMessage{data: make([]byte, size)}
+	for i := range msg.data {
+		msg.data[i] = byte(i % 251)
+	}
+	return msg
+}
+
+func TestFragment(t *testing.T) {
+	for _, tags := range []string{"", "gzip,crc32c", "gzipstream,hmac"} {
+		addr := <-testBindAddrs
+		lis, serverch := newServersetts("server", addr, newtagsetts(tags, true))
+		transc := newClientsetts("client", addr, newtagsetts(tags, false))
+		transc.SubscribeMessage(&blobMessage{}, nil)
+		if err := transc.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		transv := <-serverch
+		transv.SubscribeMessage(
+			&blobMessage{},
+			func(s *Stream, rxmsg BinMessage) StreamCallback {
+				var m blobMessage
+				m.Decode(rxmsg.Data)
+				s.Response(&m, true)
+				return nil
+			})
+
+		for _, size := range []int{0, 100, 511, 512, 4096, 100 * 1024} {
+			msg, resp := newblob(size), &blobMessage{}
+			if err := transc.Request(msg, true, resp); err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(resp.data, msg.data) {
+				t.Errorf("%q:%v unexpected response %v", tags, size, len(resp.data))
+			}
+		}
+		cstats, sstats := transc.Stat(), transv.Stat()
+		// including whoami request during handshake.
+		if !verify(cstats, "n_txreq", 7, "n_rxresp", 7) {
+			t.Errorf("%q: unexpected cstats %v", tags, cstats)
+		} else if !verify(sstats, "n_rxreq", 7, "n_txresp", 7) {
+			t.Errorf("%q: unexpected sstats %v", tags, sstats)
+		} else if cstats["n_txfrag"] == 0 || cstats["n_txfrag"] != sstats["n_rxfrag"] {
+			t.Errorf("%q: unexpected frags %v %v", tags, cstats, sstats)
+		}
+
+		lis.Close()
+		transc.Close()
+		transv.Close()
+	}
+}
+
+func TestFragmentStream(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	transc.SubscribeMessage(&blobMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	rxch := make(chan []byte, 10)
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			rxch <- append([]byte{}, rxmsg.Data...)
+			return func(rxmsg BinMessage, ok bool) {
+				if ok {
+					rxch <- append([]byte{}, rxmsg.Data...)
+				}
+			}
+		})
+
+	msg := newblob(2048)
+	stream, err := transc.Stream(msg, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 4; i++ {
+		if err := stream.Stream(newblob(2048*i), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 5; i++ {
+		select {
+		case data := <-rxch:
+			if ref := newblob(2048 * (i - 1)).data; i > 1 && !bytes.Equal(data, ref) {
+				t.Errorf("unexpected stream message %v", len(data))
+			} else if i == 1 && !bytes.Equal(data, msg.data) {
+				t.Errorf("unexpected start message %v", len(data))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for stream message %v", i)
+		}
+	}
+	stream.Close()
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["maxmessagesize"] = 4096
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	transc := newClient("client", addr, "")
+	transc.SubscribeMessage(&blobMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	rxch := make(chan int, 10)
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			rxch <- len(rxmsg.Data)
+			return nil
+		})
+
+	if err := transc.Post(newblob(8192), true); err != nil {
+		t.Fatal(err)
+	} else if err := transc.Post(newblob(4096), true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-rxch:
+		if n != 4096 {
+			t.Errorf("expected %v, got %v", 4096, n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for message")
+	}
+	if stats := transv.Stat(); !verify(stats, "n_mdrops", 1, "n_rxpost", 1) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestFragsize(t *testing.T) {
+	if n := fragsize(512, 0); n != 512-fragOverhead {
+		t.Errorf("expected %v, got %v", 512-fragOverhead, n)
+	}
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["buffersize"], setts["tags"] = 256, "gzip,lzw"
+	conn := newTestConnection("laddr", "raddr", nil, false)
+	ver := testVersion(1)
+	if _, err := NewTransport("fragsize", conn, &ver, setts); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["maxmessagesize"] = 4096
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&blobMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(BinMessage, bool) {}
+		})
+
+	msg, resp := newblob(4097), &blobMessage{}
+	if err := transc.Post(msg, true); err != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+	} else if err = transc.Request(msg, true, resp); err != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+	} else if call := <-transc.Go(msg, resp, nil).Done; call.Error != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, call.Error)
+	} else if _, err = transc.Stream(msg, true, nil); err != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+	}
+	stream, err := transc.Stream(newblob(4096), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = stream.Stream(msg, true); err != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+	} else if err = stream.Stream(newblob(10), true); err != nil {
+		t.Error(err)
+	}
+	stream.Close()
+	if stats := transc.Stat(); !verify(stats, "n_txpost", 0, "n_txstart", 1) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestReassemble(t *testing.T) {
+	trans := &Transport{maxmsgsize: 100, reassembly: 150, bufs: newbufpool(512)}
+	rf := newrxfrags()
+	frame := func(opaque, id, more uint64, data string) rxpacket {
+		msg := BinMessage{ID: id, Data: []byte(data)}
+		return rxpacket{opaque: opaque, msg: msg, more: more}
+	}
+
+	// continuation frame for another message id.
+	if _, ok := trans.reassemble(rf, frame(1, 10, 6, "abc")); ok {
+		t.Errorf("expected partial message")
+	} else if _, ok := trans.reassemble(rf, frame(1, 11, 0, "def")); ok {
+		t.Errorf("expected message to be dropped")
+	} else if !verify(trans.Stat(), "n_corrupt", 1, "n_mdrops", 1) {
+		t.Errorf("unexpected stats %v", trans.Stat())
+	}
+	// continuation frame exceeding the total.
+	if _, ok := trans.reassemble(rf, frame(1, 10, 4, "abc")); ok {
+		t.Errorf("expected partial message")
+	} else if _, ok := trans.reassemble(rf, frame(1, 10, 0, "def")); ok {
+		t.Errorf("expected message to be dropped")
+	} else if !verify(trans.Stat(), "n_corrupt", 2, "n_mdrops", 2) {
+		t.Errorf("unexpected stats %v", trans.Stat())
+	} else if len(rf.frags) != 0 || rf.size != 0 {
+		t.Errorf("unexpected frags %v, size %v", len(rf.frags), rf.size)
+	}
+	// reassembly limit across opaques.
+	trans.reassemble(rf, frame(1, 10, 100, "abc"))
+	if _, ok := trans.reassemble(rf, frame(2, 10, 60, "abc")); ok {
+		t.Errorf("expected partial message")
+	} else if _, ok := trans.reassemble(rf, frame(2, 10, 0, "def")); ok {
+		t.Errorf("expected message to be dropped")
+	} else if !verify(trans.Stat(), "n_corrupt", 3, "n_mdrops", 3) {
+		t.Errorf("unexpected stats %v", trans.Stat())
+	} else if rf.size != 100 {
+		t.Errorf("expected %v, got %v", 100, rf.size)
+	}
+	// reservation is released when the stream finishes.
+	rxpkt := frame(1, 0, 0, "")
+	rxpkt.finish = true
+	if _, ok := trans.reassemble(rf, rxpkt); !ok {
+		t.Errorf("expected finish")
+	} else if len(rf.frags) != 0 || rf.size != 0 {
+		t.Errorf("unexpected frags %v, size %v", len(rf.frags), rf.size)
+	}
+	trans.reassemble(rf, frame(2, 10, 6, "abc"))
+	if rxpkt, ok := trans.reassemble(rf, frame(2, 10, 0, "def")); !ok {
+		t.Errorf("expected message")
+	} else if s := string(rxpkt.msg.Data); s != "abcdef" {
+		t.Errorf("expected %v, got %v", "abcdef", s)
+	} else if !verify(trans.Stat(), "n_corrupt", 3, "n_mdrops", 3) {
+		t.Errorf("unexpected stats %v", trans.Stat())
+	}
+}
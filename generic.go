@@ -0,0 +1,65 @@
+//go:build go1.18
+// +build go1.18
+
+package gofast
+
+import "fmt"
+import "sync"
+import "context"
+import "reflect"
+
+// Handle subscribe message type T on transport, incoming messages are
+// decoded into T before dispatching handler. T must be a pointer type,
+// like *MyMessage, and instances of T are pooled by the transport, hence
+// handler shall not hold on to the message after it returns.
+//
+// NOTE: handler shall not block and must be as light-weight as possible
+func Handle[T Message](
+	t *Transport, handler func(*Stream, T) StreamCallback) *Transport {
+
+	pool := &sync.Pool{New: func() interface{} { return newmessage[T]() }}
+	return t.SubscribeMessage(
+		newmessage[T](),
+		func(s *Stream, bmsg BinMessage) StreamCallback {
+			msg := pool.Get().(T)
+			defer pool.Put(msg)
+			msg.Decode(bmsg.Data)
+			return handler(s, msg)
+		})
+}
+
+// Invoke request a response of type Resp from peer, Resp must be a
+// pointer type, like *MyResponse. Refer to Transport.Request() for
+// more information. This is the typed equivalent of Request, named
+// Invoke instead of Call, since Call is already the type returned by
+// Transport.Go().
+func Invoke[Req, Resp Message](t *Transport, req Req) (Resp, error) {
+	resp := newmessage[Resp]()
+	if err := t.Request(req, true /*flush*/, resp); err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp, nil
+}
+
+// InvokeContext is same as Invoke, but shall give up when ctx is done.
+// Refer to Transport.RequestContext() for more information.
+func InvokeContext[Req, Resp Message](
+	ctx context.Context, t *Transport, req Req) (Resp, error) {
+
+	resp := newmessage[Resp]()
+	if err := t.RequestContext(ctx, req, true /*flush*/, resp); err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp, nil
+}
+
+// newmessage allocate a new instance of T, that is pointer to message.
+func newmessage[T Message]() T {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Ptr {
+		panic(fmt.Errorf("message type %v must be a pointer", typ))
+	}
+	return reflect.New(typ.Elem()).Interface().(T)
+}
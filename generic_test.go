@@ -0,0 +1,61 @@
+//go:build go1.18
+// +build go1.18
+
+package gofast
+
+import "testing"
+import "time"
+import "context"
+import "reflect"
+
+func TestHandleInvoke(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	Handle(transv, func(s *Stream, msg *testMessage) StreamCallback {
+		s.Response(&testMessage{msg.count + 1}, true)
+		return nil
+	})
+	ref := &testMessage{1235}
+	if resp, err := Invoke[*testMessage, *testMessage](
+		transc, &testMessage{1234}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, ref) {
+		t.Errorf("expected %v, got %v", ref, resp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if resp, err := InvokeContext[*testMessage, *testMessage](
+		ctx, transc, &testMessage{1234}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, ref) {
+		t.Errorf("expected %v, got %v", ref, resp)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestNewMessage(t *testing.T) {
+	if msg := newmessage[*testMessage](); msg == nil {
+		t.Errorf("expected new message")
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic for non-pointer message")
+			}
+		}()
+		newmessage[Message]()
+	}()
+}
@@ -0,0 +1,116 @@
+package gofast
+
+import "runtime/debug"
+
+// rxjob is an incoming message, or a notification, that shall be
+// handled by application's RequestCallback or StreamCallback.
+type rxjob struct {
+	stream  *Stream
+	msg     BinMessage
+	rxcallb StreamCallback // captured by syncRx for local streams.
+	gen     uint64
+	post    bool
+	request bool
+	start   bool
+	ok      bool // second argument to StreamCallback.
+	release bool // release local stream after callback.
+}
+
+// reqdone is sent by dispatcher routines to syncRx, once the handler
+// has returned for an incoming request.
+type reqdone struct {
+	stream  *Stream
+	gen     uint64
+	rxcallb StreamCallback
+}
+
+// dispatch job for opaque, if "dispatch.workers" is configured job is
+// queued to a worker, always the same worker for the same opaque, so
+// that callbacks for a stream are dispatched in order. Otherwise job is
+// handled inline. Called only by syncRx. While the worker is busy,
+// keep accepting handled requests from workers, so that workers are
+// never blocked on syncRx.
+func (t *Transport) dispatch(
+	opaque uint64, job rxjob, ctrl *Stream) (StreamCallback, bool) {
+
+	if len(t.workers) == 0 {
+		return t.runjob(job, ctrl), false
+	}
+	jobch := t.workers[opaque%uint64(len(t.workers))]
+	for {
+		select {
+		case jobch <- job:
+			return nil, true
+		case done := <-t.handledch:
+			t.handled = append(t.handled, done)
+		case <-t.killch:
+			return nil, false
+		}
+	}
+}
+
+// localcallb shall return rxcallb for local streams, for remote streams
+// rxcallb is owned by the dispatcher.
+func localcallb(stream *Stream) StreamCallback {
+	if stream.remote {
+		return nil
+	}
+	return stream.rxcallb
+}
+
+// runjob shall return the StreamCallback returned by RequestCallback,
+// if job is an incoming request.
+func (t *Transport) runjob(job rxjob, ctrl *Stream) StreamCallback {
+	stream := job.stream
+	switch {
+	case job.post:
+		t.requestCallback(nil /*stream*/, job.msg)
+
+	case job.request:
+		return t.requestCallback(stream, job.msg)
+
+	case job.start: // remote streams' rxcallb is owned by dispatcher.
+		stream.rxcallb = t.requestCallback(stream, job.msg)
+
+	default:
+		rxcallb := job.rxcallb
+		if rxcallb == nil && stream.remote {
+			rxcallb = stream.rxcallb
+		}
+		if rxcallb != nil {
+			rxcallb(job.msg, job.ok)
+		}
+		if job.ok {
+			t.rxconsumed(stream, ctrl)
+		}
+		if job.release {
+			t.pStrms <- stream
+		}
+	}
+	return nil
+}
+
+// doDispatch handles jobs queued by syncRx, till syncRx closes jobch.
+func (t *Transport) doDispatch(jobch chan rxjob) {
+	ctrl := t.newctrlstream()
+	defer t.workerwg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("doDispatch() panic: %v\n", r)
+			errorf("\n%s", getStackTrace(2, debug.Stack()))
+			go t.Close()
+		}
+	}()
+
+	for job := range jobch {
+		rxcallb := t.runjob(job, ctrl)
+		if job.request {
+			done := reqdone{stream: job.stream, gen: job.gen, rxcallb: rxcallb}
+			select {
+			case t.handledch <- done:
+			case <-t.killch:
+			}
+		}
+		t.releasemsg(job.msg)
+	}
+}
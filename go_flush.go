@@ -2,13 +2,15 @@ package gofast
 
 import "time"
 
-// FlushPeriod to periodically flush batched packets.
+// FlushPeriod to periodically flush batched packets. Unlike
+// "batch.linger", that is checked only when packets are queued, this
+// shall also flush a batch left idle.
 func (t *Transport) FlushPeriod(ms time.Duration) {
 	tick := time.Tick(ms)
 	go func() {
 		for {
 			<-tick
-			if t.tx([]byte{} /*empty*/, true /*flush*/) != nil {
+			if t.tx([]byte{} /*empty*/, LaneBulk, true /*flush*/) != nil {
 				return
 			}
 
@@ -2,6 +2,7 @@ package gofast
 
 import "io"
 import "fmt"
+import "errors"
 import "strings"
 import "net"
 import "sync/atomic"
@@ -18,18 +19,30 @@ func (t *Transport) doRx() {
 
 	infof("%v doRx() started ...\n", t.logprefix)
 	pad := make([]byte, 9)
-	packet := make([]byte, t.buffersize)
 	tagouts := make(map[uint64][]byte, t.buffersize)
-	for _, factory := range tagFactory {
-		tag, _, _ := factory(t, t.settings)
-		tagouts[tag] = make([]byte, t.buffersize)
+	for _, codec := range t.tagdec {
+		tagouts[codec.tag] = make([]byte, t.buffersize)
 	}
+	frags := newrxfrags() // opaque -> partial message
 
 	for {
-		rxpkt, err := t.unframepkt(t.conn, pad, packet, tagouts)
-		if err != nil {
+		rxpkt, err := t.unframepkt(t.conn, pad, tagouts)
+		if err == errCorrupt {
+			atomic.AddUint64(&t.nCorrupt, 1)
+			if t.settings.Bool("corrupt.close") {
+				errorf("%v closing on corrupt packet\n", t.logprefix)
+				break
+			}
+			warnf("%v ##%v dropping corrupt packet\n", t.logprefix, rxpkt.opaque)
+			continue
+		} else if err != nil {
 			break
 		}
+		var ok bool
+		if rxpkt, ok = t.reassemble(frags, rxpkt); !ok {
+			continue
+		}
+		rxpkt.msg = t.leasemsg(rxpkt.msg)
 		//TODO: Issue #2, remove or prevent value escape to heap
 		//debugf("%v %v ; received pkt\n", t.logprefix, rxpkt)
 		if t.putch(t.rxch, rxpkt) == false {
@@ -39,9 +52,13 @@ func (t *Transport) doRx() {
 	infof("%v doRx() ... stopped\n", t.logprefix)
 }
 
+// errCorrupt if packet failed the integrity check, or carry a tag that
+// is not configured locally.
+var errCorrupt = errors.New("gofast.corrupt")
+
 func (t *Transport) unframepkt(
 	conn Transporter,
-	pad, packet []byte,
+	pad []byte,
 	tagouts map[uint64][]byte) (rxpkt rxpacket, err error) {
 
 	var n, m int
@@ -68,6 +85,8 @@ func (t *Transport) unframepkt(
 	n = 3
 	post, request := pad[n] == 0xc6, pad[n] == 0x81
 	start, stream, finish := pad[n] == 0x9f, pad[n] == 0xc7, pad[n] == 0xc8
+	cancel := pad[n] == 0xc9
+	finish = finish || cancel // cancel is also an end-packet.
 	n++
 
 	ln, m := cborItemLength(pad[n:])
@@ -75,8 +94,16 @@ func (t *Transport) unframepkt(
 	if finish { // railing 0xff
 		ln++
 	}
+	if ln > int64(t.buffersize) {
+		err = fmt.Errorf("%v packet size %v exceeds buffersize", t.logprefix, ln)
+		atomic.AddUint64(&t.nDropped, 9)
+		errorf("%v\n", err)
+		return
+	}
 
 	// read the full packet
+	packet := t.bufs.get(int(ln))
+	defer t.bufs.put(packet)
 	n = copy(packet, pad[n:])
 	if m, err = io.ReadFull(conn, packet[n:ln]); err == io.EOF {
 		infof("%v doRx() received EOF\n", t.logprefix)
@@ -99,23 +126,52 @@ func (t *Transport) unframepkt(
 	rxpkt.opaque, payload = readtp(packet[:ln])
 	rxpkt.post, rxpkt.request = post, request
 	rxpkt.start, rxpkt.strmsg, rxpkt.finish = start, stream, finish
-	if rxpkt.finish { // end-of-stream
-		return
-	}
-	// tags
+	rxpkt.cancel = cancel
+	// tags, end-of-stream packets carry only integrity tags, if any.
 	var tag uint64
-	tag, payload = readtp(payload)
+	if rxpkt.finish && len(payload) == 0 {
+		tag = tagMsg
+	} else {
+		tag, payload = readtp(payload)
+	}
+	// tags are unwrapped in the reverse order of t.tagdec, tags skipped
+	// by remote are allowed, but not out of order tags.
+	from, seen := len(t.tagdec)-1, uint64(0)
 	for tag != tagMsg && len(payload) > 0 {
-		n = t.tagdec[tag](payload, tagouts[tag])
+		i := t.tagindex(t.tagdec, tag, from)
+		if i < 0 {
+			warnf("%v ##%v unexpected tag %v\n", t.logprefix, rxpkt.opaque, tag)
+			err = errCorrupt
+			return
+		}
+		n = t.tagdec[i].fn(payload, tagouts[tag])
+		if n < 0 { // integrity check
+			err = errCorrupt
+			return
+		}
 		tag, payload = readtp(tagouts[tag][:n])
+		from, seen = i-1, seen|(1<<uint(i))
 	}
 	if finish == false {
-		rxpkt.msg = t.unmessage(rxpkt.opaque, payload)
+		rxpkt.msg, rxpkt.more = t.unmessage(rxpkt.opaque, payload)
+	}
+	// whoami and auth are exchanged before remote could have settled on
+	// the tags, rest of the packets shall carry the required tags.
+	switch rxpkt.msg.ID {
+	case msgWhoami, msgAuth:
+	default:
+		if (atomic.LoadUint64(&t.tagreq) &^ seen) != 0 {
+			fmsg := "%v ##%v missing required tags\n"
+			warnf(fmsg, t.logprefix, rxpkt.opaque)
+			err = errCorrupt
+		}
 	}
 	return
 }
 
-func (t *Transport) unmessage(opaque uint64, msgdata []byte) (bmsg BinMessage) {
+func (t *Transport) unmessage(
+	opaque uint64, msgdata []byte) (bmsg BinMessage, more uint64) {
+
 	msglen := len(msgdata)
 	if msglen > 0 {
 		if msgdata[0] != 0xbf {
@@ -128,7 +184,7 @@ func (t *Transport) unmessage(opaque uint64, msgdata []byte) (bmsg BinMessage) {
 	}
 	n := 1
 	var v int
-	var id int64
+	var id, total int64
 	var data []byte
 	for (n < msglen-1) && msgdata[n] != 0xff {
 		tag, k := cborItemLength(msgdata[n:])
@@ -142,6 +198,9 @@ func (t *Transport) unmessage(opaque uint64, msgdata []byte) (bmsg BinMessage) {
 			n += m
 			data = msgdata[n : n+int(ln)]
 			n += int(ln)
+		case tagMore:
+			total, v = cborItemLength(msgdata[n:])
+			n += v
 		default:
 			warnf("%v unknown tag in header %v,%v\n", t.logprefix, n, tag)
 		}
@@ -157,7 +216,7 @@ func (t *Transport) unmessage(opaque uint64, msgdata []byte) (bmsg BinMessage) {
 		errorf("%v ##%v rx invalid message packet\n", t.logprefix, opaque)
 		return
 	}
-	bmsg.ID = uint64(id)
+	bmsg.ID, more = uint64(id), uint64(total)
 	bmsg.Data = t.getdata(len(data))
 	copy(bmsg.Data, data)
 	return
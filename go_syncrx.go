@@ -13,22 +13,46 @@ type rxpacket struct {
 	start   bool
 	strmsg  bool
 	finish  bool
+	cancel  bool   // local side gave up (stream update), or remote did.
+	more    uint64 // total length, if more frames are to follow.
+	gen     uint64 // stream generation, for cancel.
+}
+
+// remotereq tracks an incoming request until remote's opaque is reused,
+// so that a cancel from remote can be notified to the handler.
+type remotereq struct {
+	stream    *Stream
+	gen       uint64
+	rxcallb   StreamCallback
+	cancelled bool // remote cancelled before handler returned.
 }
 
 func (t *Transport) syncRx() {
 	chansize := t.chansize
 	livestreams := make(map[uint64]*Stream)
+	// local streams cancelled by application, waiting for remote to
+	// complete its response or finish its stream.
+	cancelled := make(map[uint64]*Stream)
+	// incoming requests, that remote might cancel.
+	remotereqs := make(map[uint64]remotereq)
+	// scratch stream to grant credits for incoming streams.
+	ctrl := t.newctrlstream()
+	t.startworkers()
 	defer func() {
 		if r := recover(); r != nil {
 			errorf("syncRx() panic: %v\n", r)
 			errorf("\n%s", getStackTrace(2, debug.Stack()))
 			go t.Close()
 		}
+		t.stopworkers()
 		// unblock routines waiting on this stream
 		for _, stream := range livestreams {
-			if stream.rxcallb != nil {
-				stream.rxcallb(BinMessage{}, false)
+			if stream.rxresp { // requestor is already unblocked.
+				continue
 			}
+			t.rxclosed(stream)
+			job := rxjob{stream: stream, rxcallb: stream.rxcallb}
+			t.runjob(job, ctrl)
 		}
 		t.flushrxch()
 	}()
@@ -51,19 +75,153 @@ func (t *Transport) syncRx() {
 		}
 	}
 
-	handlepkt := func(rxpkt rxpacket) {
+	// local stream could not be transmitted, tracked or not.
+	streamdrop := func(stream *Stream) {
+		delete(livestreams, stream.opaque)
+		stream.rxcallb = nil
+		t.pStrms <- stream
+	}
+
+	streamcancel := func(rxpkt rxpacket) {
+		stream := rxpkt.stream
+		if s, ok := livestreams[stream.opaque]; !ok || s != stream {
+			return // already closed by remote
+		} else if atomic.LoadUint64(&stream.gen) != rxpkt.gen {
+			return // already closed by remote, and reused.
+		} else if !stream.txend(streamCancelled) {
+			return // already closed by local, wait for remote to finish.
+		}
+		delete(livestreams, stream.opaque)
+		t.rxclosed(stream)
+		if rxpkt.request && stream.rxresp { // response raced with cancel
+			stream.rxcallb = nil
+			t.pStrms <- stream
+			return
+		} else if !rxpkt.request && stream.rxcallb != nil { // local stream
+			job := rxjob{stream: stream, rxcallb: stream.rxcallb}
+			t.dispatch(stream.opaque, job, ctrl)
+		}
+		t.txcancel(stream)
+		stream.rxcallb = nil
+		cancelled[stream.opaque] = stream
+	}
+
+	// remote has cancelled an incoming request or stream, acknowledge
+	// the cancel unless we have already responded or finished.
+	remotecancel := func(stream *Stream, req bool) bool {
+		atomic.AddUint64(&t.nRxcancel, 1)
+		ok := stream.txend(streamCancelled)
+		if ok {
+			t.txcancel(stream)
+		}
+		return ok || !req
+	}
+
+	// notify handler about remote's cancel.
+	notifycancel := func(stream *Stream, rxcallb StreamCallback) {
+		job := rxjob{stream: stream, rxcallb: rxcallb}
+		job.msg = BinMessage{ID: msgCancel}
+		t.dispatch(stream.opaque, job, ctrl)
+	}
+
+	// handler has returned for an incoming request.
+	reqhandled := func(stream *Stream, gen uint64, rxcallb StreamCallback) {
+		req, ok := remotereqs[stream.opaque]
+		if !ok || req.stream != stream || req.gen != gen {
+			return // remote has reused the opaque.
+		} else if atomic.LoadUint64(&stream.gen) != gen {
+			delete(remotereqs, stream.opaque) // responded, and reused.
+			return
+		}
+		if req.cancelled {
+			delete(remotereqs, stream.opaque)
+			if rxcallb != nil {
+				notifycancel(stream, rxcallb)
+			}
+		} else if atomic.LoadUint32(&stream.txstate) == streamOpen {
+			req.rxcallb = rxcallb
+			remotereqs[stream.opaque] = req
+		} else {
+			delete(remotereqs, stream.opaque)
+		}
+	}
+
+	handledrop := func(rxpkt rxpacket) bool {
+		stream, ok := cancelled[rxpkt.opaque]
+		if !ok {
+			return false
+		}
+		if rxpkt.cancel { // remote acknowledged our cancel.
+			atomic.AddUint64(&t.nRxcancel, 1)
+		} else if rxpkt.finish {
+			atomic.AddUint64(&t.nRxfin, 1)
+		} else {
+			atomic.AddUint64(&t.nMdrops, 1)
+		}
+		if rxpkt.finish || rxpkt.request { // remote is done, reclaim.
+			delete(cancelled, rxpkt.opaque)
+			t.pStrms <- stream
+		}
+		return true
+	}
+
+	// handlepkt shall return true if rxpkt.msg is handed over to a
+	// dispatcher routine.
+	handlepkt := func(rxpkt rxpacket) (queued bool) {
 		stream, streamok := livestreams[rxpkt.opaque]
+		if !streamok && handledrop(rxpkt) {
+			return
+		}
+
+		if streamok && rxpkt.cancel && stream.remote {
+			delete(livestreams, rxpkt.opaque)
+			t.rxclosed(stream)
+			remotecancel(stream, false /*req*/)
+			notifycancel(stream, nil /*owned by dispatcher*/)
+			return
 
-		if streamok && rxpkt.finish {
+		} else if streamok && rxpkt.cancel { // remote refused local stream.
+			delete(livestreams, rxpkt.opaque)
+			t.rxclosed(stream)
+			atomic.AddUint64(&t.nRxcancel, 1)
+			stream.txend(streamCancelled)
+			job := rxjob{stream: stream, rxcallb: stream.rxcallb}
+			job.msg, job.release = BinMessage{ID: msgCancel}, true
+			t.dispatch(rxpkt.opaque, job, ctrl)
+			return
+
+		} else if streamok && rxpkt.finish {
 			//TODO: Issue #2, remove or prevent value escape to heap
 			//fmsg := "%v ##%d stream closed by remote ...\n"
 			//debugf(fmsg, t.logprefix, stream.opaque)
-			if stream.rxcallb != nil {
-				stream.rxcallb(BinMessage{}, false)
+			t.rxclosed(stream)
+			if stream.remote { // remote is done, so are we.
+				stream.txend(streamClosed)
 			}
-			t.putstream(rxpkt.opaque, stream, false /*tellrx*/)
+			job := rxjob{stream: stream, rxcallb: localcallb(stream)}
+			job.release = stream.remote == false
 			delete(livestreams, rxpkt.opaque)
 			atomic.AddUint64(&t.nRxfin, 1)
+			t.dispatch(rxpkt.opaque, job, ctrl)
+			return
+
+		} else if rxpkt.cancel {
+			req, ok := remotereqs[rxpkt.opaque]
+			if ok && atomic.LoadUint64(&req.stream.gen) == req.gen {
+				notify := remotecancel(req.stream, true /*req*/)
+				if req.rxcallb == nil && notify { // handler yet to return.
+					req.cancelled = true
+					remotereqs[rxpkt.opaque] = req
+					return
+				}
+				delete(remotereqs, rxpkt.opaque)
+				if req.rxcallb != nil && notify {
+					notifycancel(req.stream, req.rxcallb)
+				}
+				return
+			}
+			delete(remotereqs, rxpkt.opaque)
+			atomic.AddUint64(&t.nMdrops, 1)
 			return
 
 		} else if rxpkt.finish {
@@ -76,18 +234,31 @@ func (t *Transport) syncRx() {
 		//TODO: Issue #2, remove or prevent value escape to heap
 		//fmsg := "%v received msg %#v streamok:%v\n"
 		//debugf(fmsg, t.logprefix, rxpkt.msg.ID, streamok)
-		if streamok == false { // post, request, stream-start
+		if streamok == false && t.rejectpkt(rxpkt) {
+			return
+
+		} else if streamok == false { // post, request, stream-start
 			if rxpkt.post {
-				t.requestCallback(nil /*stream*/, rxpkt.msg)
+				job := rxjob{msg: rxpkt.msg, post: true}
+				_, queued = t.dispatch(rxpkt.opaque, job, ctrl)
 				atomic.AddUint64(&t.nRxpost, 1)
 			} else if rxpkt.request {
-				stream = t.newremotestream(rxpkt.opaque)
-				t.requestCallback(stream, rxpkt.msg)
+				stream = t.newremotestream(rxpkt.opaque, rxpkt.msg.ID)
+				gen := atomic.LoadUint64(&stream.gen)
+				remotereqs[rxpkt.opaque] = remotereq{stream: stream, gen: gen}
+				job := rxjob{stream: stream, msg: rxpkt.msg, gen: gen}
+				job.request = true
+				var rxcallb StreamCallback
+				rxcallb, queued = t.dispatch(rxpkt.opaque, job, ctrl)
+				if !queued {
+					reqhandled(stream, gen, rxcallb)
+				}
 				atomic.AddUint64(&t.nRxreq, 1)
 			} else if rxpkt.start { // stream
-				stream = t.newremotestream(rxpkt.opaque)
-				stream.rxcallb = t.requestCallback(stream, rxpkt.msg)
+				stream = t.newremotestream(rxpkt.opaque, rxpkt.msg.ID)
 				livestreams[stream.opaque] = stream
+				job := rxjob{stream: stream, msg: rxpkt.msg, start: true}
+				_, queued = t.dispatch(rxpkt.opaque, job, ctrl)
 				atomic.AddUint64(&t.nRxstart, 1)
 			} else { // message for a closed stream.
 				atomic.AddUint64(&t.nMdrops, 1)
@@ -95,24 +266,38 @@ func (t *Transport) syncRx() {
 			return
 		}
 
-		// response and stream - finish is already handled above
-		if stream.rxcallb != nil {
-			if rxpkt.request {
-				stream.rxcallb(rxpkt.msg, false)
-			} else {
-				stream.rxcallb(rxpkt.msg, true)
-			}
+		// window update from remote, not to be seen by application.
+		if rxpkt.strmsg && rxpkt.msg.ID == msgWindow {
+			t.rxcredits(stream, rxpkt.msg)
+			atomic.AddUint64(&t.nRxstream, 1)
+			return
 		}
 
+		// response and stream - finish is already handled above
 		if streamok && rxpkt.request { //means response
+			// responses are always handled inline, so that handlers
+			// can make requests to remote.
+			if stream.rxcallb != nil {
+				stream.rxcallb(rxpkt.msg, false)
+			}
+			stream.rxresp = true
 			atomic.AddUint64(&t.nRxresp, 1)
+			if stream.oneshot { // nobody is waiting to release.
+				stream.rxcallb = nil
+				delete(livestreams, stream.opaque)
+				t.pStrms <- stream
+			}
 		} else if rxpkt.strmsg {
+			job := rxjob{stream: stream, msg: rxpkt.msg, ok: true}
+			job.rxcallb = localcallb(stream)
+			_, queued = t.dispatch(rxpkt.opaque, job, ctrl)
 			atomic.AddUint64(&t.nRxstream, 1)
 		} else {
 			fmsg := "%v duplicate rxpkt ##%d for stream ##%d %#v ...\n"
 			warnf(fmsg, t.logprefix, rxpkt.opaque, stream.opaque, rxpkt)
 			atomic.AddUint64(&t.nMdrops, 1)
 		}
+		return
 	}
 
 	go t.doRx()
@@ -121,16 +306,29 @@ func (t *Transport) syncRx() {
 	infof(fmsg, t.logprefix, chansize)
 loop:
 	for {
+		// requests handled by workers, while syncRx was dispatching.
+		for len(t.handled) > 0 {
+			done := t.handled[0]
+			t.handled = t.handled[1:]
+			reqhandled(done.stream, done.gen, done.rxcallb)
+		}
 		select {
+		case done := <-t.handledch:
+			reqhandled(done.stream, done.gen, done.rxcallb)
 		case rxpkt := <-t.rxch:
-			if rxpkt.stream != nil {
+			if rxpkt.stream != nil && rxpkt.cancel {
+				streamcancel(rxpkt)
+				rxpkt.stream = nil
+			} else if rxpkt.stream != nil && rxpkt.finish {
+				streamdrop(rxpkt.stream)
+				rxpkt.stream = nil
+			} else if rxpkt.stream != nil {
 				streamupdate(rxpkt.stream)
 				rxpkt.stream = nil
 			} else {
-				handlepkt(rxpkt)
-				if rxpkt.msg.Data != nil {
-					t.putdata(rxpkt.msg.Data)
-					rxpkt.msg.Data = nil
+				queued := handlepkt(rxpkt)
+				if !queued {
+					t.releasemsg(rxpkt.msg)
 				}
 				atomic.AddUint64(&t.nRx, 1)
 			}
@@ -142,6 +340,68 @@ loop:
 	infof("%v syncRx() ... stopped\n", t.logprefix)
 }
 
+// rejectpkt shall reject new post, request and stream from remote, once
+// transport is going away or if remote is yet to be authenticated.
+// Reserved messages are always allowed.
+func (t *Transport) rejectpkt(rxpkt rxpacket) bool {
+	var msg *errorMsg
+	if isReservedMsg(rxpkt.msg.ID) {
+		return false
+	} else if atomic.LoadUint32(&t.txgoaway) == 1 {
+		msg = &errorMsg{code: ErrorCodeGoaway, text: ErrGoaway.Error()}
+	} else if !t.authenticated() {
+		msg = &errorMsg{code: ErrorCodeAuth, text: ErrAuthFailed.Error()}
+	} else {
+		return false
+	}
+	if rxpkt.request {
+		stream := t.newremotestream(rxpkt.opaque, rxpkt.msg.ID)
+		if err := stream.Response(msg, true /*flush*/); err != nil {
+			errorf("%v ##%d reject: %v\n", t.logprefix, rxpkt.opaque, err)
+		}
+		atomic.AddUint64(&t.nRxreq, 1)
+		return true
+
+	} else if rxpkt.start {
+		stream := t.newremotestream(rxpkt.opaque, rxpkt.msg.ID)
+		if stream.txend(streamCancelled) {
+			t.txcancel(stream)
+		}
+		atomic.AddUint64(&t.nRxstart, 1)
+		return true
+	}
+	atomic.AddUint64(&t.nMdrops, 1) // post or message for closed stream.
+	return true
+}
+
+// startworkers shall spawn "dispatch.workers" routines, to dispatch
+// application callbacks.
+func (t *Transport) startworkers() {
+	if t.nworkers == 0 {
+		return
+	}
+	size := t.chansize / t.nworkers
+	if size == 0 {
+		size = 1
+	}
+	t.workers = make([]chan rxjob, t.nworkers)
+	t.handledch = make(chan reqdone, t.nworkers)
+	for i := range t.workers {
+		t.workers[i] = make(chan rxjob, size)
+		t.workerwg.Add(1)
+		go t.doDispatch(t.workers[i])
+	}
+}
+
+// stopworkers and wait for them to handle queued jobs.
+func (t *Transport) stopworkers() {
+	for _, jobch := range t.workers {
+		close(jobch)
+	}
+	t.workerwg.Wait()
+	t.workers = nil
+}
+
 func (t *Transport) putch(ch chan rxpacket, val rxpacket) bool {
 	select {
 	case ch <- val:
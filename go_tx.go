@@ -1,8 +1,10 @@
 package gofast
 
 import "fmt"
+import "net"
 import "runtime/debug"
 import "sync/atomic"
+import "time"
 
 func (t *Transport) doTx() {
 	defer func() {
@@ -14,7 +16,14 @@ func (t *Transport) doTx() {
 	}()
 
 	batch := make([]*txproto, 0, 64)
-	tcpwriteBuf := make([]byte, t.batchsize*t.buffersize)
+	writev := t.writev()
+	var tcpwriteBuf []byte
+	var iov [][]byte
+	if writev == nil {
+		tcpwriteBuf = make([]byte, t.batchsize*t.buffersize)
+	} else {
+		iov = make([][]byte, 0, 64)
+	}
 
 	drainbuffers := func() {
 		atomic.AddUint64(&t.nFlushes, 1)
@@ -22,14 +31,32 @@ func (t *Transport) doTx() {
 		m, n := 0, 0
 		// consolidate.
 		for _, arg := range batch {
-			if len(arg.packet) > 0 {
+			if len(arg.packet) == 0 {
+				continue
+			} else if writev != nil {
+				iov = append(iov, arg.packet)
+				n += len(arg.packet)
+			} else {
 				//fmt.Println(hexstring(arg.packet))
 				n += copy(tcpwriteBuf[n:], arg.packet)
-				atomic.AddUint64(&t.nTx, 1)
 			}
+			atomic.AddUint64(&t.nTx, 1)
+			atomic.AddUint64(&t.nTxlanes[arg.lane], 1)
 		}
 		// send.
-		if n > 0 {
+		if n > 0 && writev != nil {
+			bufs := net.Buffers(iov)
+			var x int64
+			x, err = writev(&bufs)
+			if m = int(x); m != n && err == nil {
+				err = fmt.Errorf("wrote only %d, expected %d", m, n)
+			}
+			for i := range iov {
+				iov[i] = nil
+			}
+			iov = iov[:0]
+
+		} else if n > 0 {
 			//TODO: Issue #2, remove or prevent value escape to heap
 			//fmsg := "%v doTx() socket write %v:%v\n"
 			//debugf(fmsg, t.logprefix, n, tcpwriteBuf[:n])
@@ -43,7 +70,12 @@ func (t *Transport) doTx() {
 		for _, arg := range batch {
 			arg.n, arg.err = len(arg.packet), err
 			if arg.async {
-				arg.packet = arg.packet[:cap(arg.packet)]
+				if arg.stream != nil { // last packet for its opaque.
+					t.pStrms <- arg.stream
+					arg.stream = nil
+				}
+				t.bufs.put(arg.packet)
+				arg.packet = nil
 				t.pTxcmd <- arg
 			} else {
 				arg.respch <- arg
@@ -54,19 +86,49 @@ func (t *Transport) doTx() {
 		batch = batch[:0] // reset the batch
 	}
 
-	infof("%v doTx(batch:%v) started ...\n", t.logprefix, t.batchsize)
-loop:
-	for {
-		select {
-		case arg := <-t.txch:
-			batch = append(batch, arg)
-			if arg.flush || uint64(len(batch)) >= t.batchsize {
-				drainbuffers()
-			}
+	// oldest is the time when the first packet of current batch was
+	// queued, valid only if linger is configured.
+	var oldest time.Time
 
-		case <-t.killch:
-			break loop
+	infof("%v doTx(batch:%v) started ...\n", t.logprefix, t.batchsize)
+	// packets are picked from higher priority lanes first.
+	for arg := t.txpoll(); arg != nil; arg = t.txpoll() {
+		linger := atomic.LoadInt64(&t.linger)
+		if len(batch) == 0 && linger > 0 {
+			oldest = time.Now()
+		}
+		batch = append(batch, arg)
+		if arg.flush || uint64(len(batch)) >= t.batchsize {
+			drainbuffers()
+		} else if t.txidle() { // nothing more to batch.
+			drainbuffers()
+		} else if linger > 0 && lingered(oldest, linger) {
+			drainbuffers()
 		}
 	}
 	infof("%v doTx() ... stopped\n", t.logprefix)
 }
+
+// lingered shall return true if packets batched since oldest have
+// waited for linger nanoseconds or more.
+func lingered(oldest time.Time, linger int64) bool {
+	return time.Since(oldest) >= time.Duration(linger)
+}
+
+// writev shall return a vectored write function for the connection, if
+// configured with "tx.writev" and if the connection supports it, else
+// return nil to copy the batch into a single buffer.
+func (t *Transport) writev() func(bufs *net.Buffers) (int64, error) {
+	if !t.settings.Bool("tx.writev") {
+		return nil
+	}
+	switch conn := t.conn.(type) {
+	case BuffersWriter:
+		return conn.WriteBuffers
+	case *net.TCPConn:
+		return func(bufs *net.Buffers) (int64, error) {
+			return bufs.WriteTo(conn)
+		}
+	}
+	return nil
+}
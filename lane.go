@@ -0,0 +1,133 @@
+package gofast
+
+import "fmt"
+import "sync/atomic"
+
+// Lane is a priority class for packets queued for transmission. Within
+// every batch, doTx shall drain packets from higher priority lanes first,
+// so that heartbeats and latency sensitive requests are not held behind
+// a backlog of bulk stream data.
+type Lane uint8
+
+const (
+	// LaneControl for heartbeats, whoami, ping and other transport
+	// messages.
+	LaneControl Lane = iota
+	// LaneHigh for latency sensitive application messages.
+	LaneHigh
+	// LaneBulk for rest of the application messages, this is the
+	// default lane.
+	LaneBulk
+)
+
+const nlanes = int(LaneBulk) + 1
+
+func (lane Lane) String() string {
+	switch lane {
+	case LaneControl:
+		return "control"
+	case LaneHigh:
+		return "high"
+	case LaneBulk:
+		return "bulk"
+	}
+	return fmt.Sprintf("lane(%d)", uint8(lane))
+}
+
+// laneMsg wraps an application message to transmit it on a lane.
+type laneMsg struct {
+	Message
+	lane Lane
+}
+
+// WithLane shall return msg wrapped to be transmitted on lane, for a
+// single call to Post, Request, Go, Stream or Stream.Response, overriding
+// the lane set for its message id. Messages on an open stream always
+// travel in the lane the stream was started with, to preserve their order.
+func WithLane(msg Message, lane Lane) Message {
+	if lane >= Lane(nlanes) {
+		panic(fmt.Errorf("invalid %v", lane))
+	}
+	return &laneMsg{Message: msg, lane: lane}
+}
+
+// SetLane shall transmit all messages with the same id as msg on lane,
+// unless overridden by WithLane(). Responses and streams for requests
+// and streams started by remote, with this message id, shall also be
+// transmitted on lane. Lanes are local to this end of the transport.
+// Unlike SubscribeMessage, this shall be called before Handshake().
+func (t *Transport) SetLane(msg Message, lane Lane) *Transport {
+	if lane >= Lane(nlanes) {
+		panic(fmt.Errorf("%v invalid %v", t.logprefix, lane))
+	}
+	t.lanes[msg.ID()] = lane
+	return t
+}
+
+// msglane shall return the lane for msg, as wrapped by WithLane(),
+// else as set for its message id, else LaneBulk.
+func (t *Transport) msglane(msg Message) Lane {
+	if lmsg, ok := msg.(*laneMsg); ok {
+		return lmsg.lane
+	}
+	return t.idlane(msg.ID())
+}
+
+// idlane shall return the lane set for message id, else LaneBulk.
+func (t *Transport) idlane(id uint64) Lane {
+	if lane, ok := t.lanes[id]; ok {
+		return lane
+	}
+	return LaneBulk
+}
+
+// unlane shall return the application message wrapped by WithLane().
+func unlane(msg Message) Message {
+	if lmsg, ok := msg.(*laneMsg); ok {
+		return lmsg.Message
+	}
+	return msg
+}
+
+// txlane shall return the lane to queue packets meant for lane. Stateful
+// tags require packets to be transmitted in the order they are framed,
+// hence all packets are queued on LaneBulk.
+func (t *Transport) txlane(lane Lane) Lane {
+	if atomic.LoadUint32(&t.txordered) == 1 {
+		return LaneBulk
+	}
+	return lane
+}
+
+// txpoll shall return the next packet to transmit, from the highest
+// priority lane that is not empty, blocking till a packet is queued.
+// Return nil if transport is closed.
+func (t *Transport) txpoll() *txproto {
+	for _, ch := range t.txch {
+		select {
+		case arg := <-ch:
+			return arg
+		default:
+		}
+	}
+	select {
+	case arg := <-t.txch[LaneControl]:
+		return arg
+	case arg := <-t.txch[LaneHigh]:
+		return arg
+	case arg := <-t.txch[LaneBulk]:
+		return arg
+	case <-t.killch:
+		return nil
+	}
+}
+
+// txidle shall return true if there are no packets queued in any lane.
+func (t *Transport) txidle() bool {
+	for _, ch := range t.txch {
+		if len(ch) > 0 {
+			return false
+		}
+	}
+	return true
+}
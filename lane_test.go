@@ -0,0 +1,240 @@
+package gofast
+
+import "net"
+import "testing"
+import "time"
+
+func TestLanePoll(t *testing.T) {
+	trans := &Transport{killch: make(chan struct{})}
+	for i := range trans.txch {
+		trans.txch[i] = make(chan *txproto, 10)
+	}
+	// queue in the reverse order of priority.
+	for _, lane := range []Lane{LaneBulk, LaneHigh, LaneBulk, LaneControl} {
+		trans.txch[lane] <- &txproto{lane: lane}
+	}
+	refs := []Lane{LaneControl, LaneHigh, LaneBulk, LaneBulk}
+	for i, ref := range refs {
+		if trans.txidle() {
+			t.Fatalf("unexpected idle at %v", i)
+		} else if arg := trans.txpoll(); arg.lane != ref {
+			t.Errorf("%v expected %v, got %v", i, ref, arg.lane)
+		}
+	}
+	if !trans.txidle() {
+		t.Errorf("expected idle")
+	}
+	close(trans.killch)
+	if arg := trans.txpoll(); arg != nil {
+		t.Errorf("expected nil, got %v", arg)
+	}
+}
+
+func TestLaneString(t *testing.T) {
+	refs := map[Lane]string{
+		LaneControl: "control", LaneHigh: "high", LaneBulk: "bulk",
+		Lane(10): "lane(10)",
+	}
+	for lane, ref := range refs {
+		if s := lane.String(); s != ref {
+			t.Errorf("expected %v, got %v", ref, s)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	WithLane(&testMessage{}, Lane(nlanes))
+}
+
+func TestLaneTransport(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transc.SetLane(&emptyMessage{}, LaneHigh)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			if s == nil { // post
+				return nil
+			}
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+	transv.SubscribeMessage(
+		&emptyMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil
+		})
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(BinMessage, bool) {}
+		})
+
+	cCounts := transc.Stat()
+	control := cCounts["n_txcontrol"]
+	if !verify(cCounts, "n_txhigh", "n_txbulk", 0) || control == 0 {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	}
+	// per message id, per call, and default lanes.
+	if err := transc.Post(&emptyMessage{}, true); err != nil {
+		t.Fatal(err)
+	} else if err := transc.Post(&testMessage{1}, true); err != nil {
+		t.Fatal(err)
+	}
+	msg := WithLane(&emptyMessage{}, LaneControl)
+	if err := transc.Post(msg, true); err != nil {
+		t.Fatal(err)
+	}
+	// lanes are local, response is sent in the lane set by server.
+	resp := &testMessage{}
+	msg = WithLane(&testMessage{2}, LaneHigh)
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Fatal(err)
+	} else if resp.count != 2 {
+		t.Errorf("expected %v, got %v", 2, resp.count)
+	} else if _, err := transc.Ping("lane"); err != nil {
+		t.Fatal(err)
+	}
+	// all messages on stream in the lane of stream-start.
+	msg = WithLane(newblob(10), LaneHigh)
+	stream, err := transc.Stream(msg, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = stream.Stream(newblob(10), true); err != nil {
+		t.Fatal(err)
+	}
+	msg = WithLane(newblob(10), LaneControl)
+	if err = stream.Stream(msg, true); err != nil {
+		t.Fatal(err)
+	} else if err = stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cCounts, sCounts := transc.Stat(), transv.Stat()
+	if ref, n := control+2, cCounts["n_txcontrol"]; n != ref {
+		t.Errorf("expected %v, got %v", ref, n)
+	} else if !verify(cCounts, "n_txhigh", 6, "n_txbulk", 1) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	} else if !verify(sCounts, "n_rxpost", 3, "n_txbulk", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_rxstream", 2) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestLaneOrdered(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "gzipstream")
+	transc := newClient("client", addr, "gzipstream")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil
+		})
+	base := transc.Stat()
+	msg := WithLane(&testMessage{1}, LaneHigh)
+	if err := transc.Post(msg, true); err != nil {
+		t.Fatal(err)
+	} else if _, err := transc.Ping("lane"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	counts := transc.Stat()
+	if n := counts["n_txhigh"]; n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	} else if x, y := counts["n_txbulk"], base["n_txbulk"]+2; x != y {
+		t.Errorf("expected %v, got %v", y, x)
+	} else if !verify(transv.Stat(), "n_rxpost", 1) {
+		t.Errorf("unexpected counts %v", transv.Stat())
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestLaneOpaque(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	// single opaque, shared by posts across lanes.
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+11)
+	setts["batchsize"] = 64
+	ver := testVersion(1)
+	transc, err := NewTransport("client", &slowConn{conn}, &ver, setts)
+	if err != nil {
+		panic(err)
+	} else if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil
+		})
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil
+		})
+
+	// opaque is not reused in high lane till bulk fragments are written.
+	for i := 0; i < 3; i++ {
+		if err := transc.Post(newblob(64*1024), false); err != nil {
+			t.Fatal(err)
+		}
+		msg := WithLane(&testMessage{uint64(i)}, LaneHigh)
+		if err := transc.Post(msg, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if sCounts := transv.Stat(); !verify(sCounts, "n_rxpost", 6) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_mdrops", 0) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+// slowConn delays every write, to hold packets queued in lanes.
+type slowConn struct {
+	net.Conn
+}
+
+func (conn *slowConn) Write(b []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return conn.Conn.Write(b)
+}
@@ -0,0 +1,103 @@
+package gofast
+
+import "fmt"
+import "sync"
+import "sync/atomic"
+
+// lease on BinMessage.Data, shared by all copies of the BinMessage.
+// Transport holds a reference till the callback returns, handlers can
+// hold more references via Retain(). Data is given back to the pool
+// once all references are released.
+type lease struct {
+	t    *Transport
+	data []byte
+	refs int32
+}
+
+// leasePoison fills released buffers, if "lease.debug" is true.
+const leasePoison = 0xdd
+
+// number of released buffers to quarantine, if "lease.debug" is true.
+const leaseQuarantine = 64
+
+// Retain BinMessage.Data beyond the callback, without copying. Every
+// Retain shall be paired with a Release, after which Data shall not be
+// used. Retain and Release are no-ops for messages that are not
+// received from a transport.
+func (bmsg BinMessage) Retain() {
+	if bmsg.lease == nil {
+		return
+	} else if atomic.AddInt32(&bmsg.lease.refs, 1) <= 1 {
+		panic(fmt.Errorf("BinMessage.Retain(): use after release"))
+	}
+}
+
+// Release BinMessage.Data retained by Retain.
+func (bmsg BinMessage) Release() {
+	if bmsg.lease != nil {
+		bmsg.lease.t.release(bmsg.lease)
+	}
+}
+
+// leasemsg shall lease bmsg.Data, transport holds the first reference.
+func (t *Transport) leasemsg(bmsg BinMessage) BinMessage {
+	if bmsg.Data == nil {
+		return bmsg
+	}
+	l := t.pLease.Get().(*lease)
+	l.t, l.data, l.refs = t, bmsg.Data, 1
+	bmsg.lease = l
+	return bmsg
+}
+
+// releasemsg shall release transport's reference on bmsg.Data.
+func (t *Transport) releasemsg(bmsg BinMessage) {
+	if bmsg.lease != nil {
+		t.release(bmsg.lease)
+	} else if bmsg.Data != nil {
+		t.putdata(bmsg.Data)
+	}
+}
+
+func (t *Transport) release(l *lease) {
+	if refs := atomic.AddInt32(&l.refs, -1); refs > 0 {
+		return
+	} else if refs < 0 {
+		panic(fmt.Errorf("BinMessage.Release(): use after release"))
+	}
+	if t.leasedebug {
+		t.quarantine(l.data)
+		return // lease is not recycled, to catch use after release.
+	}
+	t.putdata(l.data)
+	l.data = nil
+	t.pLease.Put(l)
+}
+
+// leaseguard shall keep released buffers out of the pool, poisoned,
+// and check that they are not modified after release.
+type leaseguard struct {
+	mu      sync.Mutex
+	buffers [][]byte
+}
+
+func (t *Transport) quarantine(data []byte) {
+	for i := range data {
+		data[i] = leasePoison
+	}
+	g := &t.leaseguard
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.buffers) == leaseQuarantine {
+		evicted := g.buffers[0]
+		copy(g.buffers, g.buffers[1:])
+		g.buffers = g.buffers[:len(g.buffers)-1]
+		for _, b := range evicted {
+			if b != leasePoison {
+				fmsg := "%v BinMessage.Data modified after release"
+				panic(fmt.Errorf(fmsg, t.logprefix))
+			}
+		}
+	}
+	g.buffers = append(g.buffers, data)
+}
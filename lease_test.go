@@ -0,0 +1,137 @@
+package gofast
+
+import "bytes"
+import "sync"
+import "testing"
+import "time"
+
+func newleasetransport(debug bool) *Transport {
+	return &Transport{
+		bufs:       newbufpool(512),
+		leasedebug: debug,
+		pLease: &sync.Pool{
+			New: func() interface{} { return &lease{} },
+		},
+	}
+}
+
+func TestLease(t *testing.T) {
+	trans := newleasetransport(false /*debug*/)
+	bmsg := BinMessage{ID: msgTest, Data: trans.getdata(10)}
+	bmsg = trans.leasemsg(bmsg)
+	bmsg.Retain()
+	trans.releasemsg(bmsg) // transport's reference.
+	if len(trans.bufs.pools[0]) != 0 {
+		t.Errorf("expected retained buffer to be out of pool")
+	}
+	bmsg.Release()
+	if len(trans.bufs.pools[0]) != 1 {
+		t.Errorf("expected buffer back in pool")
+	}
+
+	// messages not received from transport.
+	bmsg = BinMessage{ID: msgTest, Data: []byte("hello")}
+	bmsg.Retain()
+	bmsg.Release()
+}
+
+func TestLeaseDebug(t *testing.T) {
+	trans := newleasetransport(true /*debug*/)
+	bmsg := trans.leasemsg(BinMessage{ID: msgTest, Data: trans.getdata(10)})
+	bmsg.Retain()
+	trans.releasemsg(bmsg)
+	bmsg.Release()
+	if ref := bytes.Repeat([]byte{leasePoison}, 10); !bytes.Equal(bmsg.Data, ref) {
+		t.Errorf("expected %v, got %v", ref, bmsg.Data)
+	} else if len(trans.bufs.pools[0]) != 0 {
+		t.Errorf("expected released buffer to be out of pool")
+	}
+
+	func() { // double release.
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		bmsg.Release()
+	}()
+	func() { // retain after release.
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		bmsg.Retain()
+	}()
+
+	// modify after release.
+	trans = newleasetransport(true /*debug*/)
+	bmsg = trans.leasemsg(BinMessage{ID: msgTest, Data: trans.getdata(10)})
+	trans.releasemsg(bmsg)
+	bmsg.Data[0] = 10
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	for i := 0; i < leaseQuarantine; i++ {
+		data := trans.getdata(10)
+		trans.releasemsg(trans.leasemsg(BinMessage{ID: msgTest, Data: data}))
+	}
+}
+
+func TestLeaseTransport(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["lease.debug"] = true
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	transc := newClient("client", addr, "")
+	transc.SubscribeMessage(&blobMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	msgch := make(chan BinMessage, 10)
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			rxmsg.Retain()
+			msgch <- rxmsg
+			return nil
+		})
+
+	for _, size := range []int{10, 1000} {
+		msg := newblob(size)
+		if err := transc.Post(msg, true); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case rxmsg := <-msgch:
+			time.Sleep(10 * time.Millisecond) // callback has returned.
+			if !bytes.Equal(rxmsg.Data, msg.data) {
+				t.Errorf("unexpected retained message %v", rxmsg.Data)
+			}
+			rxmsg.Release()
+			ref := bytes.Repeat([]byte{leasePoison}, size)
+			if !bytes.Equal(rxmsg.Data, ref) {
+				t.Errorf("expected poisoned buffer, got %v", rxmsg.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message")
+		}
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func BenchmarkLease(b *testing.B) {
+	trans := newleasetransport(false /*debug*/)
+	for i := 0; i < b.N; i++ {
+		bmsg := trans.leasemsg(BinMessage{ID: msgTest, Data: trans.getdata(10)})
+		bmsg.Retain()
+		trans.releasemsg(bmsg)
+		bmsg.Release()
+	}
+}
@@ -9,10 +9,16 @@ const (
 	msgPing             = 0x1001 // to ping/echo with peer.
 	msgWhoami           = 0x1002 // to supplying/obtaining peer info.
 	msgHeartbeat        = 0x1003 // to send/receive heartbeat.
+	msgError            = 0x1004 // to respond with an error.
+	msgCancel           = 0x1005 // to notify remote cancel, never on wire.
+	msgWindow           = 0x1006 // to grant stream credits to remote.
+	msgGoaway           = 0x1007 // to tell remote that we are going away.
+	msgAuth             = 0x1008 // to authenticate with remote.
 	msgEnd              = 0x100f // reserve end.
 )
 
-// handler for whoamiMsg, pingMsg, heartbeatMsg messages.
+// handler for whoamiMsg, pingMsg, heartbeatMsg, goawayMsg, authMsg
+// messages.
 func (t *Transport) msghandler(stream *Stream, msg BinMessage) StreamCallback {
 	switch msg.ID {
 	case msgHeartbeat:
@@ -34,21 +40,65 @@ func (t *Transport) msghandler(stream *Stream, msg BinMessage) StreamCallback {
 		m.transport = t
 		typeOfVersion := reflect.ValueOf(t.version).Elem().Type()
 		m.version = reflect.New(typeOfVersion).Interface().(Version)
-		m.Decode(msg.Data)
+		if _, err := m.decode(msg.Data); err != nil {
+			t.malformed(msg, err)
+			if err := stream.ResponseError(err, true /*flush*/); err != nil {
+				errorf("%v response-whoami: %v\n", t.logprefix, err)
+			}
+			break
+		}
 		t.peerver.Store(m.version)
+		atomic.StoreInt64(&t.peerwindow, int64(m.window))
+		// negotiate before responding, so that tags are applied for
+		// all messages after remote's handshake, failure is reported
+		// by Handshake.
+		t.negotiate(&m)
 		rv := newWhoami(t) // respond back
+		rv.mac = whoamimac(t, &m, rv)
 		if err := stream.Response(rv, true /*flush*/); err != nil {
 			errorf("%v response-whoami: %v\n", t.logprefix, err)
-		} else {
+		} else if t.auth == nil { // else, wait till remote is verified.
 			atomic.AddInt64(&t.xchngok, 1)
 		}
 
+	case msgGoaway:
+		m := &goawayMsg{}
+		if _, err := m.decode(msg.Data); err != nil {
+			t.malformed(msg, err)
+			break
+		}
+		t.rxgoaway.Store(m)
+		infof("%v remote going away %v\n", t.logprefix, m.Repr())
+
+	case msgAuth:
+		m := &authMsg{}
+		if _, err := m.decode(msg.Data); err != nil {
+			t.malformed(msg, err)
+			if err := stream.ResponseError(err, true /*flush*/); err != nil {
+				errorf("%v response-auth: %v\n", t.logprefix, err)
+			}
+			break
+		}
+		t.authhandler(stream, m)
+
 	default:
 		errorf("%v message %T:%v not expected\n", t.logprefix, msg, msg)
 	}
 	return nil
 }
 
+// malformed predefined message from remote, shall be dropped.
+func (t *Transport) malformed(msg BinMessage, err error) {
+	atomic.AddUint64(&t.nMdrops, 1)
+	errorf("%v message %v: %v\n", t.logprefix, msg.ID, err)
+}
+
+// decoder is implemented by predefined messages, to reject malformed
+// messages from remote.
+type decoder interface {
+	decode(in []byte) (int64, error)
+}
+
 func isReservedMsg(id uint64) bool {
 	return (msgStart <= id) && (id <= msgEnd)
 }
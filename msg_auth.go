@@ -0,0 +1,70 @@
+package gofast
+
+import "fmt"
+import "encoding/binary"
+
+// authentication steps, initiated by the node proving its identity.
+const (
+	authHello     byte = iota + 1 // ask remote for a challenge.
+	authChallenge                 // challenge from remote.
+	authResponse                  // response for remote's challenge.
+	authOk                        // remote verified the response.
+)
+
+// authMsg is predefined message exchanged during Handshake, when
+// transport is configured with an Authenticator.
+type authMsg struct {
+	op   byte
+	data []byte
+}
+
+func newAuth(op byte, data []byte) *authMsg {
+	return &authMsg{op: op, data: data}
+}
+
+func (msg *authMsg) ID() uint64 {
+	return msgAuth
+}
+
+func (msg *authMsg) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	out[0] = msg.op
+	n := 1
+	binary.BigEndian.PutUint16(out[n:], uint16(len(msg.data)))
+	n += 2
+	n += copy(out[n:], msg.data)
+	return out[:n]
+}
+
+func (msg *authMsg) Decode(in []byte) int64 {
+	n, _ := msg.decode(in)
+	return n
+}
+
+// decode is same as Decode, but shall fail with ErrMalformedMessage
+// instead of reading past the end of in.
+func (msg *authMsg) decode(in []byte) (int64, error) {
+	if len(in) < 3 {
+		return 0, ErrMalformedMessage
+	}
+	msg.op, in = in[0], in[1:]
+	ln, n := int64(binary.BigEndian.Uint16(in)), int64(2)
+	if n+ln > int64(len(in)) {
+		return 0, ErrMalformedMessage
+	}
+	msg.data = make([]byte, ln)
+	n += int64(copy(msg.data, in[n:n+ln]))
+	return 1 + n, nil
+}
+
+func (msg *authMsg) Size() int64 {
+	return 1 + 2 + int64(len(msg.data))
+}
+
+func (msg *authMsg) String() string {
+	return "authMsg"
+}
+
+func (msg *authMsg) Repr() string {
+	return fmt.Sprintf("authMsg:%v:%v", msg.op, len(msg.data))
+}
@@ -0,0 +1,67 @@
+package gofast
+
+import "testing"
+import "bytes"
+import "reflect"
+
+func TestAuthEncode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := []byte{2, 0, 4, 1, 2, 3, 4}
+	msg := newAuth(authChallenge, []byte{1, 2, 3, 4})
+	if out := msg.Encode(out); bytes.Compare(ref, out) != 0 {
+		t.Errorf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestAuthDecode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := newAuth(authResponse, []byte("response"))
+	out = ref.Encode(out)
+	msg := &authMsg{}
+	if n := msg.Decode(out); n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	} else if !reflect.DeepEqual(ref, msg) {
+		t.Errorf("expected %v, got %v", ref, msg)
+	}
+}
+
+func TestAuthMalformed(t *testing.T) {
+	out := make([]byte, 1024)
+	out = newAuth(authResponse, []byte("response")).Encode(out)
+	for i := 0; i < len(out); i++ {
+		msg := &authMsg{}
+		if n, err := msg.decode(out[:i]); err != ErrMalformedMessage {
+			t.Errorf("%v: expected %v, got %v", i, ErrMalformedMessage, err)
+		} else if n != 0 {
+			t.Errorf("%v: expected %v, got %v", i, 0, n)
+		}
+	}
+}
+
+func TestAuthMisc(t *testing.T) {
+	msg := newAuth(authHello, []byte{})
+	if msg.String() != "authMsg" {
+		t.Errorf("expected authMsg, got %v", msg.String())
+	}
+	if ref := "authMsg:1:0"; ref != msg.Repr() {
+		t.Errorf("expected %v, got %v", ref, msg.Repr())
+	}
+}
+
+func BenchmarkAuthEncode(b *testing.B) {
+	out := make([]byte, 1024)
+	msg := newAuth(authResponse, make([]byte, 32))
+	for i := 0; i < b.N; i++ {
+		msg.Encode(out)
+	}
+}
+
+func BenchmarkAuthDecode(b *testing.B) {
+	out := make([]byte, 1024)
+	ref := newAuth(authResponse, make([]byte, 32))
+	out = ref.Encode(out)
+	msg := &authMsg{}
+	for i := 0; i < b.N; i++ {
+		msg.Decode(out)
+	}
+}
@@ -0,0 +1,103 @@
+package gofast
+
+import "fmt"
+import "strconv"
+import "encoding/binary"
+
+// ErrorCodeUnknown is the code used by Stream.ResponseError() when
+// supplied error is not a *RemoteError, or when its code is reserved.
+const ErrorCodeUnknown uint64 = 1
+
+// ErrorCodeGoaway is reserved, used by transport to reject new requests
+// when shutting down, Request() shall return ErrGoaway.
+const ErrorCodeGoaway uint64 = 2
+
+// ErrorCodeAuth is reserved, used by transport to reject requests from
+// remote that is yet to be authenticated, Request() shall return
+// ErrAuthFailed.
+const ErrorCodeAuth uint64 = 3
+
+// RemoteError is returned by Request() when remote handler responds
+// via Stream.ResponseError() instead of a response message. Codes
+// ErrorCodeGoaway and ErrorCodeAuth are reserved for transport, handlers
+// responding with them are sent as ErrorCodeUnknown, so that Request()
+// returns them as *RemoteError.
+type RemoteError struct {
+	Code uint64
+	Text string
+}
+
+// Error implement error interface{}.
+func (err *RemoteError) Error() string {
+	return fmt.Sprintf("gofast.remoteerror(%v): %v", err.Code, err.Text)
+}
+
+// errorMsg is predefined message to respond with an error.
+//
+//	| code (8) | uvarint(len(text)) | text |
+type errorMsg struct {
+	code uint64
+	text string
+}
+
+func newError(err error) *errorMsg {
+	if rerr, ok := err.(*RemoteError); ok {
+		code := rerr.Code
+		if code == ErrorCodeGoaway || code == ErrorCodeAuth { // reserved
+			code = ErrorCodeUnknown
+		}
+		return &errorMsg{code: code, text: rerr.Text}
+	}
+	return &errorMsg{code: ErrorCodeUnknown, text: err.Error()}
+}
+
+func (msg *errorMsg) ID() uint64 {
+	return msgError
+}
+
+func (msg *errorMsg) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	binary.BigEndian.PutUint64(out, msg.code)
+	n := 8
+	n += binary.PutUvarint(out[n:], uint64(len(msg.text)))
+	n += copy(out[n:], msg.text)
+	return out[:n]
+}
+
+func (msg *errorMsg) Decode(in []byte) int64 {
+	n, _ := msg.decode(in)
+	return n
+}
+
+// decode is same as Decode, but shall fail with ErrMalformedMessage
+// instead of reading past the end of in.
+func (msg *errorMsg) decode(in []byte) (int64, error) {
+	if len(in) < 9 {
+		return 0, ErrMalformedMessage
+	}
+	msg.code, in = binary.BigEndian.Uint64(in), in[8:]
+	ln, n := binary.Uvarint(in)
+	if n <= 0 || ln > uint64(len(in)-n) {
+		return 0, ErrMalformedMessage
+	}
+	msg.text = string(in[n : uint64(n)+ln])
+	return 8 + int64(n) + int64(ln), nil
+}
+
+func (msg *errorMsg) Size() int64 {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], uint64(len(msg.text)))
+	return 8 + int64(n) + int64(len(msg.text))
+}
+
+func (msg *errorMsg) String() string {
+	return "errorMsg"
+}
+
+func (msg *errorMsg) Repr() string {
+	return strconv.Itoa(int(msg.code)) + ":" + msg.text
+}
+
+func (msg *errorMsg) toerror() *RemoteError {
+	return &RemoteError{Code: msg.code, Text: msg.text}
+}
@@ -0,0 +1,110 @@
+package gofast
+
+import "testing"
+import "bytes"
+import "errors"
+import "reflect"
+import "strings"
+
+func TestErrorEncode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := []byte{
+		0, 0, 0, 0, 0, 0, 0, 10, 11,
+		104, 101, 108, 108, 111, 32, 119, 111, 114, 108, 100,
+	}
+	msg := newError(&RemoteError{Code: 10, Text: "hello world"})
+	if out := msg.Encode(out); bytes.Compare(ref, out) != 0 {
+		t.Errorf("expected %v, got %v", ref, out)
+	} else if n := msg.Size(); n != int64(len(ref)) {
+		t.Errorf("expected %v, got %v", len(ref), n)
+	}
+}
+
+func TestErrorDecode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := newError(errors.New("made in india"))
+	out = ref.Encode(out)
+	msg := &errorMsg{}
+	if n := msg.Decode(out); n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	} else if !reflect.DeepEqual(ref, msg) {
+		t.Errorf("expected %v, got %v", ref, msg)
+	}
+}
+
+func TestErrorLarge(t *testing.T) {
+	text := strings.Repeat("x", 70000) // larger than uint16.
+	ref := newError(&RemoteError{Code: 10, Text: text})
+	out := ref.Encode(nil)
+	if n := ref.Size(); n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	}
+	msg := &errorMsg{}
+	if n := msg.Decode(out); n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	} else if !reflect.DeepEqual(ref, msg) {
+		t.Errorf("expected %v, got %v", len(ref.text), len(msg.text))
+	}
+}
+
+func TestErrorReserved(t *testing.T) {
+	for _, code := range []uint64{ErrorCodeGoaway, ErrorCodeAuth} {
+		msg := newError(&RemoteError{Code: code, Text: "reserved"})
+		if msg.code != ErrorCodeUnknown {
+			t.Errorf("expected %v, got %v", ErrorCodeUnknown, msg.code)
+		}
+	}
+	if msg := newError(&RemoteError{Code: 4}); msg.code != 4 {
+		t.Errorf("expected %v, got %v", 4, msg.code)
+	}
+}
+
+func TestErrorMalformed(t *testing.T) {
+	out := make([]byte, 1024)
+	out = newError(errors.New("malformed")).Encode(out)
+	for i := 0; i < len(out); i++ {
+		msg := &errorMsg{}
+		if n, err := msg.decode(out[:i]); err != ErrMalformedMessage {
+			t.Errorf("%v: expected %v, got %v", i, ErrMalformedMessage, err)
+		} else if n != 0 {
+			t.Errorf("%v: expected %v, got %v", i, 0, n)
+		}
+	}
+	// text length overflows the message.
+	out = []byte{0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0x0f, 'x'}
+	if _, err := (&errorMsg{}).decode(out); err != ErrMalformedMessage {
+		t.Errorf("expected %v, got %v", ErrMalformedMessage, err)
+	}
+}
+
+func TestErrorMisc(t *testing.T) {
+	msg := newError(errors.New("hello world"))
+	if msg.String() != "errorMsg" {
+		t.Errorf("expected errorMsg, got %v", msg.String())
+	}
+	if ref := "1:hello world"; ref != msg.Repr() {
+		t.Errorf("expected %v, got %v", ref, msg.Repr())
+	}
+	ref := "gofast.remoteerror(1): hello world"
+	if err := msg.toerror(); err.Error() != ref {
+		t.Errorf("expected %v, got %v", ref, err.Error())
+	}
+}
+
+func BenchmarkErrorEncode(b *testing.B) {
+	out := make([]byte, 1024)
+	msg := newError(errors.New("hello world"))
+	for i := 0; i < b.N; i++ {
+		msg.Encode(out)
+	}
+}
+
+func BenchmarkErrorDecode(b *testing.B) {
+	out := make([]byte, 1024)
+	ref := newError(errors.New("made in india"))
+	out = ref.Encode(out)
+	msg := &errorMsg{}
+	for i := 0; i < b.N; i++ {
+		msg.Decode(out)
+	}
+}
@@ -0,0 +1,72 @@
+package gofast
+
+import "strconv"
+import "encoding/binary"
+
+// Reason codes for GOAWAY, applications can use their own codes
+// starting from GoawayUser.
+const (
+	// GoawayShutdown remote is shutting down.
+	GoawayShutdown uint64 = iota + 1
+	// GoawayOverload remote is overloaded.
+	GoawayOverload
+	// GoawayUser is the starting code for application defined reasons.
+	GoawayUser uint64 = 0x100
+)
+
+// goawayMsg is predefined message, posted to remote when transport is
+// shutting down. Refer to Shutdown() method on the transport.
+type goawayMsg struct {
+	code   uint64
+	reason string
+}
+
+func newGoaway(code uint64, reason string) *goawayMsg {
+	return &goawayMsg{code: code, reason: reason}
+}
+
+func (msg *goawayMsg) ID() uint64 {
+	return msgGoaway
+}
+
+func (msg *goawayMsg) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	binary.BigEndian.PutUint64(out, msg.code)
+	n := 8
+	binary.BigEndian.PutUint16(out[n:], uint16(len(msg.reason)))
+	n += 2
+	n += copy(out[n:], msg.reason)
+	return out[:n]
+}
+
+func (msg *goawayMsg) Decode(in []byte) int64 {
+	n, _ := msg.decode(in)
+	return n
+}
+
+// decode is same as Decode, but shall fail with ErrMalformedMessage
+// instead of reading past the end of in.
+func (msg *goawayMsg) decode(in []byte) (int64, error) {
+	if len(in) < 10 {
+		return 0, ErrMalformedMessage
+	}
+	msg.code, in = binary.BigEndian.Uint64(in), in[8:]
+	ln, n := int64(binary.BigEndian.Uint16(in)), int64(2)
+	if n+ln > int64(len(in)) {
+		return 0, ErrMalformedMessage
+	}
+	msg.reason, n = string(in[n:n+ln]), n+ln
+	return 8 + n, nil
+}
+
+func (msg *goawayMsg) Size() int64 {
+	return 8 + 2 + int64(len(msg.reason))
+}
+
+func (msg *goawayMsg) String() string {
+	return "goawayMsg"
+}
+
+func (msg *goawayMsg) Repr() string {
+	return strconv.Itoa(int(msg.code)) + ":" + msg.reason
+}
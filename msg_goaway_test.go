@@ -0,0 +1,70 @@
+package gofast
+
+import "testing"
+import "bytes"
+import "reflect"
+
+func TestGoawayEncode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := []byte{
+		0, 0, 0, 0, 0, 0, 0, 1, 0, 8,
+		115, 104, 117, 116, 100, 111, 119, 110,
+	}
+	msg := newGoaway(GoawayShutdown, "shutdown")
+	if out := msg.Encode(out); bytes.Compare(ref, out) != 0 {
+		t.Errorf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestGoawayDecode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := newGoaway(GoawayOverload, "overload")
+	out = ref.Encode(out)
+	msg := &goawayMsg{}
+	if n := msg.Decode(out); n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	} else if !reflect.DeepEqual(ref, msg) {
+		t.Errorf("expected %v, got %v", ref, msg)
+	}
+}
+
+func TestGoawayMalformed(t *testing.T) {
+	out := make([]byte, 1024)
+	out = newGoaway(GoawayOverload, "overload").Encode(out)
+	for i := 0; i < len(out); i++ {
+		msg := &goawayMsg{}
+		if n, err := msg.decode(out[:i]); err != ErrMalformedMessage {
+			t.Errorf("%v: expected %v, got %v", i, ErrMalformedMessage, err)
+		} else if n != 0 {
+			t.Errorf("%v: expected %v, got %v", i, 0, n)
+		}
+	}
+}
+
+func TestGoawayMisc(t *testing.T) {
+	msg := newGoaway(GoawayShutdown, "shutdown")
+	if msg.String() != "goawayMsg" {
+		t.Errorf("expected goawayMsg, got %v", msg.String())
+	}
+	if ref := "1:shutdown"; ref != msg.Repr() {
+		t.Errorf("expected %v, got %v", ref, msg.Repr())
+	}
+}
+
+func BenchmarkGoawayEncode(b *testing.B) {
+	out := make([]byte, 1024)
+	msg := newGoaway(GoawayShutdown, "shutdown")
+	for i := 0; i < b.N; i++ {
+		msg.Encode(out)
+	}
+}
+
+func BenchmarkGoawayDecode(b *testing.B) {
+	out := make([]byte, 1024)
+	ref := newGoaway(GoawayShutdown, "shutdown")
+	out = ref.Encode(out)
+	msg := &goawayMsg{}
+	for i := 0; i < b.N; i++ {
+		msg.Decode(out)
+	}
+}
@@ -16,6 +16,14 @@ func TestIsReservedMsg(t *testing.T) {
 		t.Errorf("failed for msgWhoami")
 	} else if isReservedMsg(msgHeartbeat) == false {
 		t.Errorf("failed for msgHeartbeat")
+	} else if isReservedMsg(msgError) == false {
+		t.Errorf("failed for msgError")
+	} else if isReservedMsg(msgCancel) == false {
+		t.Errorf("failed for msgCancel")
+	} else if isReservedMsg(msgWindow) == false {
+		t.Errorf("failed for msgWindow")
+	} else if isReservedMsg(msgGoaway) == false {
+		t.Errorf("failed for msgGoaway")
 	}
 }
 
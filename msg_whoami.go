@@ -1,11 +1,14 @@
 package gofast
 
 import "fmt"
+import "crypto/hmac"
+import "crypto/rand"
 import "encoding/binary"
 
 // Whoami messages exchanged by remotes.
 type Whoami struct {
 	whoamiMsg
+	identity *PeerIdentity
 }
 
 // whoamiMsg is predefined message to exchange peer information.
@@ -14,7 +17,11 @@ type whoamiMsg struct {
 	name       string
 	version    Version
 	buffersize uint64
-	tags       string
+	tags       string // supported tags, in preferred order.
+	window     uint64 // stream window, ZERO disables flow control.
+	required   string // required tags.
+	salt       []byte // random, picked once for every transport.
+	mac        []byte // hmac over request and response, if configured.
 }
 
 func newWhoami(t *Transport) *whoamiMsg {
@@ -23,8 +30,11 @@ func newWhoami(t *Transport) *whoamiMsg {
 		name:       t.name,
 		version:    t.version,
 		buffersize: t.buffersize,
+		window:     t.window,
 	}
 	msg.tags = t.settings.String("tags")
+	msg.required = t.settings.String("tags.required")
+	msg.salt = t.salt
 	return msg
 }
 
@@ -49,24 +59,77 @@ func (msg *whoamiMsg) Encode(out []byte) []byte {
 	binary.BigEndian.PutUint16(out[n:], uint16(len(msg.tags)))
 	n += 2
 	n += copy(out[n:], msg.tags)
+	binary.BigEndian.PutUint64(out[n:], msg.window)
+	n += 8
+	binary.BigEndian.PutUint16(out[n:], uint16(len(msg.required)))
+	n += 2
+	n += copy(out[n:], msg.required)
+	out[n], n = byte(len(msg.salt)), n+1
+	n += copy(out[n:], msg.salt)
+	out[n], n = byte(len(msg.mac)), n+1
+	n += copy(out[n:], msg.mac)
 	return out[:n]
 }
 
 // Decode implement Message interface{}.
 func (msg *whoamiMsg) Decode(in []byte) int64 {
+	n, _ := msg.decode(in)
+	return n
+}
+
+// decode is same as Decode, but shall fail with ErrMalformedMessage
+// instead of reading past the end of in.
+func (msg *whoamiMsg) decode(in []byte) (int64, error) {
+	size := int64(len(in))
+	if size < 1 {
+		return 0, ErrMalformedMessage
+	}
 	ln, n := int64(in[0]), int64(1)
+	if n+ln+msg.version.Size() > size {
+		return 0, ErrMalformedMessage
+	}
 	msg.name, n = string(in[n:n+ln]), n+ln
-	n += msg.version.Decode(in[n:])
+	if n += msg.version.Decode(in[n:]); n+8+2 > size {
+		return 0, ErrMalformedMessage
+	}
 	msg.buffersize, n = binary.BigEndian.Uint64(in[n:]), n+8
 	ln, n = int64(binary.BigEndian.Uint16(in[n:])), n+2
+	if n+ln > size {
+		return 0, ErrMalformedMessage
+	}
 	msg.tags, n = string(in[n:n+ln]), n+ln
-	return n
+	if size >= n+8 { // older peers don't advertise window.
+		msg.window, n = binary.BigEndian.Uint64(in[n:]), n+8
+	}
+	if size >= n+2 { // older peers don't advertise required.
+		ln, n = int64(binary.BigEndian.Uint16(in[n:])), n+2
+		if n+ln > size {
+			return 0, ErrMalformedMessage
+		}
+		msg.required, n = string(in[n:n+ln]), n+ln
+	}
+	msg.salt, msg.mac = nil, nil
+	if size > n { // older peers don't advertise salt and mac.
+		ln, n = int64(in[n]), n+1
+		if n+ln+1 > size {
+			return 0, ErrMalformedMessage
+		}
+		msg.salt, n = whoamibytes(in[n:n+ln]), n+ln
+		ln, n = int64(in[n]), n+1
+		if n+ln > size {
+			return 0, ErrMalformedMessage
+		}
+		msg.mac, n = whoamibytes(in[n:n+ln]), n+ln
+	}
+	return n, nil
 }
 
 // Size implement Message interface{}.
 func (msg *whoamiMsg) Size() int64 {
 	return 1 + int64(len(msg.name)) +
-		msg.version.Size() + 8 + 2 + int64(len(msg.tags))
+		msg.version.Size() + 8 + 2 + int64(len(msg.tags)) + 8 +
+		2 + int64(len(msg.required)) +
+		1 + int64(len(msg.salt)) + 1 + int64(len(msg.mac))
 }
 
 // String implement Message interface{}.
@@ -74,6 +137,41 @@ func (msg *whoamiMsg) String() string {
 	return "whoamiMsg"
 }
 
+// verify remote's response to whoami request. If both sides support
+// "hmac", response shall carry a valid hmac over request and response,
+// else remote's tags could have been stripped by a man in the middle.
+func (msg *whoamiMsg) verify(req *whoamiMsg) error {
+	t := msg.transport
+	local := t.getTags(t.settings.String("tags"), []string{})
+	remote := t.getTags(msg.tags, []string{})
+	if !hasString("hmac", local) || !hasString("hmac", remote) {
+		return nil
+	}
+	resp := *msg
+	resp.mac = nil
+	if !hmac.Equal(whoamimac(t, req, &resp), msg.mac) {
+		errorf("%v whoami from remote failed hmac check\n", t.logprefix)
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func whoamibytes(in []byte) []byte {
+	if len(in) == 0 {
+		return nil
+	}
+	return append([]byte(nil), in...)
+}
+
+// newsalt shall return a random salt to be advertised in whoami.
+func newsalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %v", err)
+	}
+	return salt, nil
+}
+
 //---- accessors for Whoami{}.
 
 // Name return name of the transport, either local or remote based on the
@@ -94,6 +192,26 @@ func (msg *Whoami) Tags() string {
 	return msg.tags
 }
 
+// RequiredTags return comma separated value of tags that are required,
+// either local or remote based on the context in which Whoami was
+// obtained.
+func (msg *Whoami) RequiredTags() string {
+	return msg.required
+}
+
+// Window return the number of stream messages that can be sent on a
+// stream before waiting for credits, either local or remote based on the
+// context in which Whoami was obtained. ZERO means no flow control.
+func (msg *Whoami) Window() uint64 {
+	return msg.window
+}
+
+// Identity return remote's identity from its verified TLS certificate,
+// nil if connection is not TLS or remote did not present a certificate.
+func (msg *Whoami) Identity() *PeerIdentity {
+	return msg.identity
+}
+
 func (msg *whoamiMsg) Repr() string {
 	return fmt.Sprintf("%s,%v", msg.name, msg.buffersize)
 }
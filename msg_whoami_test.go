@@ -17,8 +17,10 @@ func TestWaiEncode(t *testing.T) {
 	out := make([]byte, 1024)
 	ref := []byte{
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 	}
 	wai := newWhoami(transc)
+	wai.salt = nil // salt is random.
 	if out := wai.Encode(out); bytes.Compare(ref, out) != 0 {
 		t.Errorf("expected %v, got %v", ref, out)
 	}
@@ -55,6 +57,34 @@ func TestWaiDecode(t *testing.T) {
 	transv.Close()
 }
 
+func TestWaiMalformed(t *testing.T) {
+	ver, out := testVersion(1), make([]byte, 1024)
+	ref := &whoamiMsg{
+		name: "client", version: &ver, buffersize: 1024, tags: "gzip",
+		window: 4, required: "gzip", salt: []byte{1, 2}, mac: []byte{3},
+	}
+	out = ref.Encode(out)
+	verlen := len(ver.Encode(make([]byte, 16)))
+	tagsend := 1 + len(ref.name) + verlen + 8 + 2 + len(ref.tags)
+	// truncated whoami shall not panic, and fields upto tags, and
+	// salt and mac, when advertised, shall not be cut short.
+	for i := 0; i < len(out); i++ {
+		wai := &whoamiMsg{version: new(testVersion)}
+		_, err := wai.decode(out[:i])
+		if (i < tagsend || i == len(out)-1) && err != ErrMalformedMessage {
+			t.Errorf("%v: expected %v, got %v", i, ErrMalformedMessage, err)
+		}
+	}
+	wai := &whoamiMsg{version: new(testVersion)}
+	if n, err := wai.decode(out); err != nil {
+		t.Error(err)
+	} else if n != int64(len(out)) {
+		t.Errorf("expected %v, got %v", len(out), n)
+	} else if !reflect.DeepEqual(ref, wai) {
+		t.Errorf("expected %#v, got %#v", ref, wai)
+	}
+}
+
 func TestWhoamiMisc(t *testing.T) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "") // init server
@@ -79,6 +109,55 @@ func TestWhoamiMisc(t *testing.T) {
 	transv.Close()
 }
 
+func TestWhoamiVerify(t *testing.T) {
+	ver := testVersion(1)
+	conn := newTestConnection("laddr", "raddr", nil, true)
+	setts := newtagsetts("hmac", false)
+	transc, err := NewTransport("verifyc", conn, &ver, setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setts = newtagsetts("gzip,hmac", true)
+	transv, err := NewTransport("verifyv", conn, &ver, setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, resp := newWhoami(transc), newWhoami(transv)
+	resp.mac, resp.transport = whoamimac(transv, req, resp), transc
+	if err := resp.verify(req); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	// tags stripped from response.
+	wai := *resp
+	wai.tags = "hmac"
+	if err := wai.verify(req); err != ErrAuthFailed {
+		t.Errorf("expected %v, got %v", ErrAuthFailed, err)
+	}
+	// tags stripped from request.
+	wai, xreq := *resp, *req
+	xreq.tags = ""
+	wai.mac = whoamimac(transv, &xreq, resp)
+	if err := wai.verify(req); err != ErrAuthFailed {
+		t.Errorf("expected %v, got %v", ErrAuthFailed, err)
+	}
+	// mac stripped from response.
+	wai = *resp
+	wai.mac = nil
+	if err := wai.verify(req); err != ErrAuthFailed {
+		t.Errorf("expected %v, got %v", ErrAuthFailed, err)
+	}
+	// remote does not support hmac.
+	wai = *resp
+	wai.tags, wai.mac = "gzip", nil
+	if err := wai.verify(req); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+
+	transc.Close()
+	transv.Close()
+}
+
 func BenchmarkWaiEncode(b *testing.B) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "") // init server
@@ -0,0 +1,42 @@
+package gofast
+
+import "strconv"
+import "encoding/binary"
+
+// windowMsg is predefined message, used by the receiving end of a
+// stream to grant more credits to the sending end. Refer to
+// "stream.window" settings.
+type windowMsg struct {
+	credits uint64
+}
+
+func newWindow(credits uint64) *windowMsg {
+	return &windowMsg{credits: credits}
+}
+
+func (msg *windowMsg) ID() uint64 {
+	return msgWindow
+}
+
+func (msg *windowMsg) Encode(out []byte) []byte {
+	out = fixbuffer(out, msg.Size())
+	binary.BigEndian.PutUint64(out, msg.credits)
+	return out[:msg.Size()]
+}
+
+func (msg *windowMsg) Decode(in []byte) (n int64) {
+	msg.credits, n = binary.BigEndian.Uint64(in), n+8
+	return n
+}
+
+func (msg *windowMsg) Size() int64 {
+	return 8
+}
+
+func (msg *windowMsg) String() string {
+	return "windowMsg"
+}
+
+func (msg *windowMsg) Repr() string {
+	return msg.String() + ":" + strconv.Itoa(int(msg.credits))
+}
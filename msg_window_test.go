@@ -0,0 +1,53 @@
+package gofast
+
+import "testing"
+import "bytes"
+import "reflect"
+
+func TestWindowEncode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := []byte{0, 0, 0, 0, 0, 0, 0, 128}
+	msg := newWindow(128)
+	if out = msg.Encode(out); bytes.Compare(ref, out) != 0 {
+		t.Errorf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestWindowDecode(t *testing.T) {
+	out := make([]byte, 1024)
+	ref := newWindow(128)
+	out = ref.Encode(out)
+	msg := &windowMsg{}
+	msg.Decode(out)
+	if !reflect.DeepEqual(ref, msg) {
+		t.Errorf("expected %v, got %v", ref, msg)
+	}
+}
+
+func TestWindowMisc(t *testing.T) {
+	msg := newWindow(10)
+	if msg.String() != "windowMsg" {
+		t.Errorf("expected windowMsg, got %v", msg.String())
+	}
+	if ref := "windowMsg:10"; ref != msg.Repr() {
+		t.Errorf("expected %v, got %v", ref, msg.Repr())
+	}
+}
+
+func BenchmarkWindowEncode(b *testing.B) {
+	out := make([]byte, 1024)
+	msg := newWindow(128)
+	for i := 0; i < b.N; i++ {
+		msg.Encode(out)
+	}
+}
+
+func BenchmarkWindowDecode(b *testing.B) {
+	out := make([]byte, 1024)
+	ref := newWindow(128)
+	out = ref.Encode(out)
+	msg := &windowMsg{}
+	for i := 0; i < b.N; i++ {
+		msg.Decode(out)
+	}
+}
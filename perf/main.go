@@ -20,6 +20,7 @@ var options struct {
 	log       string
 	stream    int
 	batchsize int
+	writev    bool
 	profile   bool
 
 	// client specific options.
@@ -31,6 +32,7 @@ var options struct {
 	payload    int
 	buffersize int
 	flushtick  time.Duration
+	linger     int
 
 	// server specific options.
 	//
@@ -59,10 +61,14 @@ func argParse() {
 		"buffersize for batching.")
 	flag.IntVar(&options.batchsize, "batchsize", 1,
 		"no. of messages to batch")
+	flag.BoolVar(&options.writev, "writev", true,
+		"use vectored writes for batched packets")
 	flag.BoolVar(&options.profile, "profile", false,
 		"take cpuprofile and memprofile")
 	flag.IntVar(&flushtick, "flushtick", 10,
 		"flush period in milliseconds.")
+	flag.IntVar(&options.linger, "linger", 0,
+		"microseconds oldest packet may wait in a batch")
 	flag.StringVar(&options.log, "log", "error",
 		"log level")
 
@@ -65,88 +65,85 @@ func runserver(lis net.Listener) {
 	config := newconfig(1000, opqend)
 	config["tags"] = options.tags
 	config["batchsize"] = options.batchsize
-	conncount := 0
-	for {
-		if conn, err := lis.Accept(); err == nil {
-			name := fmt.Sprintf("server-%v", conncount)
-			conncount += 1
-			fmt.Println("new transport", conn.RemoteAddr(), conn.LocalAddr())
-			trans, err := gf.NewTransport(name, conn, &ver, config)
-			if err != nil {
-				panic("NewTransport server failed")
+
+	mux := gf.NewMux()
+	mux.SubscribeMessage(
+		&msgPost{},
+		func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
+			return nil
+		})
+	mux.SubscribeMessage(
+		&msgReqsp{},
+		func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
+			var rmsg msgReqsp
+			rmsg.Decode(msg.Data)
+			if err := s.Response(&rmsg, true); err != nil {
+				log.Fatal(err)
+			}
+			return nil
+		})
+	mux.SubscribeMessage(
+		&msgStreamRx{},
+		func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
+			closeat := rand.Intn(options.stream)
+			return func(rxstrmsg gf.BinMessage, ok bool) {
+				if options.do == "verify" {
+					if closeat == 0 {
+						s.Close()
+					}
+					closeat--
+				}
+				if ok == false {
+					s.Close()
+				}
 			}
+		})
+	mux.SubscribeMessage(
+		&msgStreamTx{},
+		func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
+			go func() {
+				tmsg := &msgStreamTx{
+					data: make([]byte, options.payload),
+				}
+				for i := 0; i < options.payload; i++ {
+					tmsg.data[i] = 'a'
+				}
+				count := options.stream
+				if options.do == "verify" {
+					count = rand.Intn(options.stream)
+				}
+				for i := 0; i < count; i++ {
+					if err := s.Stream(tmsg, true); err != nil {
+						log.Printf("error stream: %v\n", err)
+					}
+				}
+				s.Close()
+			}()
+			return nil
+		})
+
+	srv := &gf.Server{
+		Name: "server", Settings: config, Version: &ver, Mux: mux,
+		Setup: func(trans *gf.Transport) {
+			fmt.Println("new transport", trans.RemoteAddr(), trans.LocalAddr())
 			mu.Lock()
 			transs = append(transs, trans)
 			mu.Unlock()
-			go func(trans *gf.Transport) {
-				trans.FlushPeriod(options.flushtick * time.Millisecond)
-				trans.SendHeartbeat(1 * time.Second)
-				trans.SubscribeMessage(
-					&msgPost{},
-					func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
-						return nil
-					})
-				trans.SubscribeMessage(
-					&msgReqsp{},
-					func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
-						var rmsg msgReqsp
-						rmsg.Decode(msg.Data)
-						if err := s.Response(&rmsg, true); err != nil {
-							log.Fatal(err)
-						}
-						return nil
-					})
-				trans.SubscribeMessage(
-					&msgStreamRx{},
-					func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
-						closeat := rand.Intn(options.stream)
-						return func(rxstrmsg gf.BinMessage, ok bool) {
-							if options.do == "verify" {
-								if closeat == 0 {
-									s.Close()
-								}
-								closeat--
-							}
-							if ok == false {
-								s.Close()
-							}
-						}
-					})
-				trans.SubscribeMessage(
-					&msgStreamTx{},
-					func(s *gf.Stream, msg gf.BinMessage) gf.StreamCallback {
-						go func() {
-							tmsg := &msgStreamTx{
-								data: make([]byte, options.payload),
-							}
-							for i := 0; i < options.payload; i++ {
-								tmsg.data[i] = 'a'
-							}
-							count := options.stream
-							if options.do == "verify" {
-								count = rand.Intn(options.stream)
-							}
-							for i := 0; i < count; i++ {
-								if err := s.Stream(tmsg, true); err != nil {
-									log.Printf("error stream: %v\n", err)
-								}
-							}
-							s.Close()
-						}()
-						return nil
-					})
-
-				if err := trans.Handshake(); err != nil {
-					panic(err)
-				}
-				tick := time.Tick(1 * time.Second)
-				for {
-					<-tick
-					if options.log == "debug" {
+			trans.FlushPeriod(options.flushtick * time.Millisecond)
+			trans.SendHeartbeat(1 * time.Second)
+			if options.log == "debug" {
+				go func() {
+					tick := time.NewTicker(1 * time.Second)
+					defer tick.Stop()
+					for !trans.IsClosed() {
+						<-tick.C
 						printCounts(trans.Stat(), 0, nil)
 					}
-				}
-			}(trans)
-		}
+				}()
+			}
+		},
+	}
+	if err := srv.Serve(lis); err != nil {
+		log.Println(err)
 	}
 }
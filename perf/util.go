@@ -126,5 +126,7 @@ func newconfig(start, end int) map[string]interface{} {
 		"opaque.end":   end,
 		"log.level":    options.log,
 		"gzip.level":   flate.BestSpeed,
+		"tx.writev":    options.writev,
+		"batch.linger": options.linger,
 	}
 }
@@ -0,0 +1,297 @@
+package gofast
+
+import "fmt"
+import "sync"
+import "time"
+import "context"
+import "math/rand"
+import "sync/atomic"
+
+import s "github.com/bnclabs/gosettings"
+
+// PoolDialFunc shall return a new transport, after Handshake, for pool
+// slot. seqno is unique for every call and can be used to name the
+// transport, slot can be used to pick one among several peers.
+type PoolDialFunc func(slot int, seqno uint64) (*Transport, error)
+
+// Pool of transports to one or more peers, calls are load balanced
+// across live transports based on "pool.policy". Closed transports are
+// evicted and re-dialed with backoff. Refer to DefaultSettings for
+// "pool.*" parameters.
+type Pool struct {
+	name       string
+	dial       PoolDialFunc
+	pick       func() *Transport
+	minbackoff time.Duration
+	maxbackoff time.Duration
+
+	mu     sync.RWMutex
+	slots  []*Transport // nil for evicted slots.
+	rrseq  uint64
+	seqno  uint64
+	killch chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewPool create a pool of "pool.size" transports, every slot is dialed
+// once before returning, slots that failed to dial are retried in the
+// background.
+func NewPool(name string, dial PoolDialFunc, setts s.Settings) *Pool {
+	// missing settings, or nil setts, shall default to DefaultSettings.
+	setts = DefaultSettings(1000, 5000).Mixin(setts)
+	size := setts.Int64("pool.size")
+	if size <= 0 {
+		panic(fmt.Errorf("invalid pool.size %v", size))
+	}
+	minbackoff := time.Duration(setts.Int64("reconnect.minbackoff"))
+	maxbackoff := time.Duration(setts.Int64("reconnect.maxbackoff"))
+	p := &Pool{
+		name:       name,
+		dial:       dial,
+		minbackoff: minbackoff * time.Millisecond,
+		maxbackoff: maxbackoff * time.Millisecond,
+		slots:      make([]*Transport, size),
+		killch:     make(chan struct{}),
+	}
+	switch policy := setts.String("pool.policy"); policy {
+	case "roundrobin":
+		p.pick = p.roundrobin
+	case "leastoutstanding":
+		p.pick = p.leastoutstanding
+	case "twochoices":
+		p.pick = p.twochoices
+	default:
+		panic(fmt.Errorf("invalid pool.policy %q", policy))
+	}
+
+	for slot := range p.slots {
+		trans, err := p.dialslot(slot)
+		if err != nil {
+			warnf("pool %v slot %v: %v\n", p.name, slot, err)
+		}
+		p.slots[slot] = trans
+		p.wg.Add(1)
+		go p.runslot(slot, trans)
+	}
+	return p
+}
+
+// Transports return a snapshot of live transports in the pool.
+func (p *Pool) Transports() []*Transport {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	transs := make([]*Transport, 0, len(p.slots))
+	for _, trans := range p.slots {
+		if usable(trans) {
+			transs = append(transs, trans)
+		}
+	}
+	return transs
+}
+
+// Close the pool and all its transports.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	select {
+	case <-p.killch:
+		p.mu.Unlock()
+		return nil
+	default:
+		close(p.killch)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+// Post request on one of the transports, refer to Transport.Post.
+func (p *Pool) Post(msg Message, flush bool) error {
+	trans, err := p.transport()
+	if err != nil {
+		return err
+	}
+	return trans.Post(msg, flush)
+}
+
+// Request a response on one of the transports, refer to
+// Transport.Request.
+func (p *Pool) Request(msg Message, flush bool, resp Message) error {
+	trans, err := p.transport()
+	if err != nil {
+		return err
+	}
+	return trans.Request(msg, flush, resp)
+}
+
+// RequestContext is same as Request, refer to Transport.RequestContext.
+func (p *Pool) RequestContext(
+	ctx context.Context, msg Message, flush bool, resp Message) error {
+
+	trans, err := p.transport()
+	if err != nil {
+		return err
+	}
+	return trans.RequestContext(ctx, msg, flush, resp)
+}
+
+// Go request a response asynchronously on one of the transports,
+// refer to Transport.Go.
+func (p *Pool) Go(msg Message, resp Message, done chan *Call) *Call {
+	trans, err := p.transport()
+	if err != nil {
+		if done == nil {
+			done = make(chan *Call, 10) // buffered.
+		}
+		call := &Call{Request: msg, Response: resp, Done: done, Error: err}
+		call.done()
+		return call
+	}
+	return trans.Go(msg, resp, done)
+}
+
+// Stream a bi-directional stream on one of the transports, refer to
+// Transport.Stream.
+func (p *Pool) Stream(
+	msg Message, flush bool, rxcallb StreamCallback) (*Stream, error) {
+
+	trans, err := p.transport()
+	if err != nil {
+		return nil, err
+	}
+	return trans.Stream(msg, flush, rxcallb)
+}
+
+// StreamContext is same as Stream, refer to Transport.StreamContext.
+func (p *Pool) StreamContext(
+	ctx context.Context, msg Message, flush bool,
+	rxcallb StreamCallback) (*Stream, error) {
+
+	trans, err := p.transport()
+	if err != nil {
+		return nil, err
+	}
+	return trans.StreamContext(ctx, msg, flush, rxcallb)
+}
+
+func (p *Pool) transport() (*Transport, error) {
+	p.mu.RLock()
+	trans := p.pick()
+	p.mu.RUnlock()
+	if trans == nil {
+		return nil, ErrDisconnected
+	}
+	return trans, nil
+}
+
+// roundrobin shall pick the next live transport in order.
+func (p *Pool) roundrobin() *Transport {
+	n := uint64(len(p.slots))
+	seq := atomic.AddUint64(&p.rrseq, 1)
+	for i := uint64(0); i < n; i++ {
+		if trans := p.slots[(seq+i)%n]; usable(trans) {
+			return trans
+		}
+	}
+	return nil
+}
+
+// leastoutstanding shall pick the live transport with least number of
+// requests waiting for response.
+func (p *Pool) leastoutstanding() *Transport {
+	var least *Transport
+	var min int64
+	for _, trans := range p.slots {
+		if !usable(trans) {
+			continue
+		}
+		if n := trans.outstanding(); least == nil || n < min {
+			least, min = trans, n
+		}
+	}
+	return least
+}
+
+// twochoices shall pick two transports at random and use the one with
+// least number of requests waiting for response.
+func (p *Pool) twochoices() *Transport {
+	n := len(p.slots)
+	if n < 2 {
+		return p.leastoutstanding()
+	}
+	x := rand.Intn(n)
+	y := rand.Intn(n - 1)
+	if y >= x {
+		y++
+	}
+	tx, ty := p.slots[x], p.slots[y]
+	if !usable(tx) || !usable(ty) {
+		return p.leastoutstanding()
+	} else if ty.outstanding() < tx.outstanding() {
+		return ty
+	}
+	return tx
+}
+
+// runslot shall own the slot, re-dial the slot whenever its transport
+// is closed, till the pool is closed.
+func (p *Pool) runslot(slot int, trans *Transport) {
+	defer p.wg.Done()
+
+	backoff := p.minbackoff
+	for {
+		if trans == nil {
+			select {
+			case <-time.After(backoff):
+			case <-p.killch:
+				return
+			}
+			var err error
+			if trans, err = p.dialslot(slot); err != nil {
+				warnf("pool %v slot %v: %v\n", p.name, slot, err)
+				if backoff *= 2; backoff > p.maxbackoff {
+					backoff = p.maxbackoff
+				}
+				continue
+			}
+		}
+		backoff = p.minbackoff
+		p.setslot(slot, trans)
+
+		select {
+		case <-trans.killch:
+			infof("pool %v slot %v evicting %v\n", p.name, slot, trans.Name())
+			p.setslot(slot, nil)
+			trans = nil
+		case <-p.killch:
+			p.setslot(slot, nil)
+			trans.Close()
+			return
+		}
+	}
+}
+
+func (p *Pool) dialslot(slot int) (*Transport, error) {
+	seqno := atomic.AddUint64(&p.seqno, 1)
+	trans, err := p.dial(slot, seqno)
+	if err != nil {
+		return nil, err
+	} else if trans == nil {
+		return nil, fmt.Errorf("dial returned nil transport")
+	}
+	return trans, nil
+}
+
+func (p *Pool) setslot(slot int, trans *Transport) {
+	p.mu.Lock()
+	p.slots[slot] = trans
+	p.mu.Unlock()
+}
+
+// usable shall return true if transport is not closed and remote has
+// not asked us to go away.
+func usable(trans *Transport) bool {
+	if trans == nil || trans.IsClosed() {
+		return false
+	}
+	return trans.rxgoaway.Load() == nil
+}
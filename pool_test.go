@@ -0,0 +1,153 @@
+package gofast
+
+import "testing"
+import "fmt"
+import "net"
+import "time"
+import "reflect"
+
+func TestPoolRoundrobin(t *testing.T) {
+	addr := <-testBindAddrs
+	srv := newPoolServer(t, addr)
+	pool := newTestPool(addr, "roundrobin", 3)
+
+	transs := pool.Transports()
+	if len(transs) != 3 {
+		t.Fatalf("expected %v, got %v", 3, len(transs))
+	}
+	for i := 0; i < 30; i++ {
+		msg, resp := &testMessage{uint64(i + 1)}, &testMessage{}
+		if err := pool.Request(msg, true, resp); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(resp, msg) {
+			t.Errorf("expected %v, got %v", msg, resp)
+		}
+	}
+	for _, trans := range transs { // +1 for handshake.
+		if n := trans.Stat()["n_txreq"]; n != 11 {
+			t.Errorf("expected %v, got %v", 11, n)
+		}
+	}
+
+	// close a transport, pool shall evict it and dial a new one.
+	transs[0].Close()
+	time.Sleep(200 * time.Millisecond)
+	if newtranss := pool.Transports(); len(newtranss) != 3 {
+		t.Errorf("expected %v, got %v", 3, len(newtranss))
+	} else {
+		for _, trans := range newtranss {
+			if trans == transs[0] {
+				t.Errorf("expected %v to be evicted", trans.Name())
+			}
+		}
+	}
+
+	pool.Close()
+	if transs := pool.Transports(); len(transs) != 0 {
+		t.Errorf("expected %v, got %v", 0, len(transs))
+	} else if err := pool.Post(&testMessage{}, true); err != ErrDisconnected {
+		t.Errorf("expected %v, got %v", ErrDisconnected, err)
+	}
+	srv.Close()
+}
+
+func TestPoolLeastOutstanding(t *testing.T) {
+	addr := <-testBindAddrs
+	srv := newPoolServer(t, addr)
+	pool := newTestPool(addr, "leastoutstanding", 2)
+
+	transs := pool.Transports()
+	// slow request shall keep the first transport busy.
+	call := pool.Go(&testMessage{0}, &testMessage{}, nil)
+	for i := 0; i < 10; i++ {
+		msg, resp := &testMessage{uint64(i + 1)}, &testMessage{}
+		if err := pool.Request(msg, true, resp); err != nil {
+			t.Error(err)
+		}
+	}
+	if call = <-call.Done; call.Error != nil {
+		t.Error(call.Error)
+	}
+	// +1 for handshake.
+	if n := transs[0].Stat()["n_txreq"]; n != 2 {
+		t.Errorf("expected %v, got %v", 2, n)
+	} else if n := transs[1].Stat()["n_txreq"]; n != 11 {
+		t.Errorf("expected %v, got %v", 11, n)
+	}
+
+	pool.Close()
+	srv.Close()
+}
+
+func TestPoolTwoChoices(t *testing.T) {
+	addr := <-testBindAddrs
+	srv := newPoolServer(t, addr)
+	pool := newTestPool(addr, "twochoices", 4)
+
+	transs := pool.Transports()
+	for i := 0; i < 100; i++ {
+		msg, resp := &testMessage{uint64(i + 1)}, &testMessage{}
+		if err := pool.Request(msg, true, resp); err != nil {
+			t.Error(err)
+		}
+	}
+	total := uint64(0)
+	for _, trans := range transs {
+		total += trans.Stat()["n_txreq"] - 1 // handshake.
+	}
+	if total != 100 {
+		t.Errorf("expected %v, got %v", 100, total)
+	}
+
+	pool.Close()
+	srv.Close()
+}
+
+func newTestPool(addr, policy string, size int) *Pool {
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["pool.size"] = size
+	setts["pool.policy"] = policy
+	setts["reconnect.minbackoff"] = 10
+	dial := func(slot int, seqno uint64) (*Transport, error) {
+		name := fmt.Sprintf("pool-%v-%v", addr, seqno)
+		trans := newClientsetts(name, addr, setts)
+		trans.SubscribeMessage(&testMessage{}, nil)
+		if err := trans.Handshake(); err != nil {
+			trans.Close()
+			return nil, err
+		}
+		return trans, nil
+	}
+	return NewPool("pool", dial, setts)
+}
+
+func newPoolServer(t *testing.T, addr string) *Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewMux().SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+
+			m.Decode(rxmsg.Data)
+			if m.count == 0 { // slow response.
+				go func() {
+					time.Sleep(200 * time.Millisecond)
+					s.Response(&m, true)
+				}()
+				return nil
+			}
+			s.Response(&m, true)
+			return nil
+		})
+	ver := testVersion(1)
+	srv := &Server{
+		Settings: newsetts(TagOpaqueStart, TagOpaqueStart+10),
+		Version:  &ver,
+		Mux:      mux,
+	}
+	go srv.Serve(lis)
+	return srv
+}
@@ -0,0 +1,422 @@
+package gofast
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "context"
+
+import s "github.com/bnclabs/gosettings"
+
+// ConnState of a ReconnectingTransport, notified via StateCallback.
+type ConnState uint32
+
+const (
+	// StateConnecting dialing remote and handshaking.
+	StateConnecting ConnState = iota + 1
+	// StateConnected handshake completed, transport is ready.
+	StateConnected
+	// StateDisconnected dial or handshake failed, or transport closed.
+	StateDisconnected
+	// StateClosed ReconnectingTransport is closed by application.
+	StateClosed
+)
+
+func (state ConnState) String() string {
+	switch state {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// DialFunc shall return a new connection to remote.
+type DialFunc func() (Transporter, error)
+
+// StateCallback handler called when ReconnectingTransport changes its
+// connection state, trans is the underlying transport if any, and err is
+// the reason for disconnection if known.
+type StateCallback func(state ConnState, trans *Transport, err error)
+
+// ReconnectingTransport encapsulate a transport that is dialed again,
+// with exponential backoff, whenever it is closed. Messages subscribed
+// on ReconnectingTransport are subscribed on every new transport before
+// Handshake.
+type ReconnectingTransport struct {
+	name    string
+	dial    DialFunc
+	version Version
+	setts   s.Settings
+
+	// configured before Start()
+	mux       *Mux
+	statecb   StateCallback
+	heartbeat time.Duration
+
+	// settings
+	queue      bool
+	minbackoff time.Duration
+	maxbackoff time.Duration
+	reqtimeout time.Duration
+	hstimeout  time.Duration
+
+	mu     sync.Mutex
+	gen    uint64        // suffixed to name for every new transport.
+	trans  *Transport    // nil while disconnected.
+	connch chan struct{} // closed when connected.
+	killch chan struct{}
+}
+
+// NewReconnectingTransport shall create a ReconnectingTransport, call
+// Start() after subscribing messages to dial remote. Refer to
+// DefaultSettings for "reconnect.*" parameters.
+func NewReconnectingTransport(
+	name string, dial DialFunc, version Version,
+	setts s.Settings) *ReconnectingTransport {
+
+	// missing settings, or nil setts, shall default to DefaultSettings.
+	setts = DefaultSettings(1000, 5000).Mixin(setts)
+	rt := &ReconnectingTransport{
+		name:    name,
+		dial:    dial,
+		version: version,
+		setts:   setts,
+		mux:     NewMux(),
+		connch:  make(chan struct{}),
+		killch:  make(chan struct{}),
+	}
+	switch policy := setts.String("reconnect.policy"); policy {
+	case "fail":
+	case "queue":
+		rt.queue = true
+	default:
+		panic(fmt.Errorf("invalid reconnect.policy %q", policy))
+	}
+	minbackoff := time.Duration(setts.Int64("reconnect.minbackoff"))
+	maxbackoff := time.Duration(setts.Int64("reconnect.maxbackoff"))
+	reqtimeout := time.Duration(setts.Int64("request.timeout"))
+	hstimeout := time.Duration(setts.Int64("reconnect.handshaketimeout"))
+	rt.minbackoff = minbackoff * time.Millisecond
+	rt.maxbackoff = maxbackoff * time.Millisecond
+	rt.reqtimeout = reqtimeout * time.Millisecond
+	rt.hstimeout = hstimeout * time.Millisecond
+	return rt
+}
+
+// TCPDialer return a DialFunc to dial remote at addr over tcp.
+func TCPDialer(addr string) DialFunc {
+	return func() (Transporter, error) {
+		return net.Dial("tcp", addr)
+	}
+}
+
+// SubscribeMessage on every new transport, refer to
+// Transport.SubscribeMessage. Shall be called before Start().
+func (rt *ReconnectingTransport) SubscribeMessage(
+	msg Message, handler RequestCallback) *ReconnectingTransport {
+
+	rt.mux.SubscribeMessage(msg, handler)
+	return rt
+}
+
+// DefaultHandler on every new transport, refer to
+// Transport.DefaultHandler. Shall be called before Start().
+func (rt *ReconnectingTransport) DefaultHandler(
+	handler RequestCallback) *ReconnectingTransport {
+
+	rt.mux.DefaultHandler(handler)
+	return rt
+}
+
+// Authenticate every new transport, refer to Transport.Authenticate.
+// Shall be called before Start().
+func (rt *ReconnectingTransport) Authenticate(
+	auth Authenticator) *ReconnectingTransport {
+
+	rt.mux.Authenticate(auth)
+	return rt
+}
+
+// StateCallback to be notified about connection state, callback is
+// dispatched serially from the reconnecting routine, hence it shall not
+// block. Shall be called before Start().
+func (rt *ReconnectingTransport) StateCallback(
+	callback StateCallback) *ReconnectingTransport {
+
+	rt.statecb = callback
+	return rt
+}
+
+// SendHeartbeat on every new transport, refer to
+// Transport.SendHeartbeat. Shall be called before Start().
+func (rt *ReconnectingTransport) SendHeartbeat(
+	ms time.Duration) *ReconnectingTransport {
+
+	rt.heartbeat = ms
+	return rt
+}
+
+// Start dialing remote, transport shall be re-dialed until
+// ReconnectingTransport is closed.
+func (rt *ReconnectingTransport) Start() {
+	go rt.run()
+}
+
+// Close ReconnectingTransport and the underlying transport.
+func (rt *ReconnectingTransport) Close() error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	select {
+	case <-rt.killch:
+	default:
+		close(rt.killch)
+	}
+	return nil
+}
+
+// Transport return the underlying transport, nil if disconnected.
+func (rt *ReconnectingTransport) Transport() *Transport {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.trans
+}
+
+// Post request to peer, refer to Transport.Post.
+func (rt *ReconnectingTransport) Post(msg Message, flush bool) error {
+	trans, err := rt.transport(context.Background())
+	if err != nil {
+		return err
+	}
+	return trans.Post(msg, flush)
+}
+
+// Request a response from peer, refer to Transport.Request. With
+// "queue" policy, time spent waiting for connection is included in
+// "request.timeout".
+func (rt *ReconnectingTransport) Request(
+	msg Message, flush bool, resp Message) error {
+
+	ctx := context.Background()
+	if rt.reqtimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.reqtimeout)
+		defer cancel()
+	}
+	return rt.RequestContext(ctx, msg, flush, resp)
+}
+
+// RequestContext is same as Request, but shall give up when ctx is
+// done, refer to Transport.RequestContext.
+func (rt *ReconnectingTransport) RequestContext(
+	ctx context.Context, msg Message, flush bool, resp Message) error {
+
+	trans, err := rt.transport(ctx)
+	if err != nil {
+		return err
+	}
+	return trans.RequestContext(ctx, msg, flush, resp)
+}
+
+// Go request a response from peer asynchronously, refer to
+// Transport.Go. With "queue" policy, if disconnected, request is sent
+// once transport is connected.
+func (rt *ReconnectingTransport) Go(
+	msg Message, resp Message, done chan *Call) *Call {
+
+	if trans := rt.Transport(); trans != nil {
+		return trans.Go(msg, resp, done)
+	}
+
+	if done == nil {
+		done = make(chan *Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		panic(fmt.Errorf("%v Go(): done channel is unbuffered", rt.name))
+	}
+	call := &Call{Request: msg, Response: resp, Done: done}
+	if !rt.queue {
+		call.Error = ErrDisconnected
+		call.done()
+		return call
+	}
+	go func() {
+		ctx := context.Background()
+		if rt.reqtimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, rt.reqtimeout)
+			defer cancel()
+		}
+		trans, err := rt.transport(ctx)
+		if err != nil {
+			call.Error = err
+			call.done()
+			return
+		}
+		c := <-trans.Go(msg, resp, make(chan *Call, 1)).Done
+		call.Error = c.Error
+		call.done()
+	}()
+	return call
+}
+
+// Stream a bi-directional stream with peer, refer to Transport.Stream.
+func (rt *ReconnectingTransport) Stream(
+	msg Message, flush bool, rxcallb StreamCallback) (*Stream, error) {
+
+	return rt.StreamContext(context.Background(), msg, flush, rxcallb)
+}
+
+// StreamContext is same as Stream, refer to Transport.StreamContext.
+func (rt *ReconnectingTransport) StreamContext(
+	ctx context.Context, msg Message, flush bool,
+	rxcallb StreamCallback) (*Stream, error) {
+
+	trans, err := rt.transport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trans.StreamContext(ctx, msg, flush, rxcallb)
+}
+
+// transport shall return the underlying transport, if disconnected
+// either fail with ErrDisconnected or wait for connection, based on
+// "reconnect.policy".
+func (rt *ReconnectingTransport) transport(
+	ctx context.Context) (*Transport, error) {
+
+	for {
+		rt.mu.Lock()
+		trans, connch := rt.trans, rt.connch
+		rt.mu.Unlock()
+
+		if trans != nil && !trans.IsClosed() {
+			return trans, nil
+		}
+		select {
+		case <-rt.killch:
+			return nil, ErrDisconnected
+		default:
+		}
+		if !rt.queue {
+			return nil, ErrDisconnected
+		}
+		select {
+		case <-connch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-rt.killch:
+			return nil, ErrDisconnected
+		}
+	}
+}
+
+func (rt *ReconnectingTransport) run() {
+	backoff := rt.minbackoff
+	for {
+		rt.notify(StateConnecting, nil, nil)
+		trans, err := rt.connect()
+		if err != nil {
+			warnf("%v reconnect: %v\n", rt.name, err)
+			rt.notify(StateDisconnected, nil, err)
+			select {
+			case <-time.After(backoff):
+			case <-rt.killch:
+				rt.notify(StateClosed, nil, nil)
+				return
+			}
+			if backoff *= 2; backoff > rt.maxbackoff {
+				backoff = rt.maxbackoff
+			}
+			continue
+		}
+
+		backoff = rt.minbackoff
+		rt.mu.Lock()
+		rt.trans = trans
+		close(rt.connch)
+		rt.mu.Unlock()
+		rt.notify(StateConnected, trans, nil)
+
+		select {
+		case <-trans.killch:
+		case <-rt.killch:
+			trans.Close()
+			rt.setdisconnected()
+			rt.notify(StateClosed, trans, nil)
+			return
+		}
+		rt.setdisconnected()
+		rt.notify(StateDisconnected, trans, nil)
+	}
+}
+
+func (rt *ReconnectingTransport) connect() (*Transport, error) {
+	select {
+	case <-rt.killch:
+		return nil, ErrDisconnected
+	default:
+	}
+
+	conn, err := rt.dial()
+	if err != nil {
+		return nil, err
+	}
+	// bound the handshake, if connection supports deadline.
+	dconn, ok := conn.(deadliner)
+	if ok && rt.hstimeout > 0 {
+		dconn.SetDeadline(time.Now().Add(rt.hstimeout))
+	}
+	// Close() shall interrupt a handshake that is stuck on remote.
+	donech := make(chan struct{})
+	defer close(donech)
+	go func() {
+		select {
+		case <-rt.killch:
+			conn.Close()
+		case <-donech:
+		}
+	}()
+
+	// transport names must be unique, suffix a generation number.
+	rt.gen++
+	name := fmt.Sprintf("%v-%v", rt.name, rt.gen)
+	trans, err := NewTransport(name, conn, rt.version, rt.setts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rt.mux.Apply(trans).Handshake(); err != nil {
+		trans.Close()
+		return nil, err
+	}
+	if ok && rt.hstimeout > 0 {
+		dconn.SetDeadline(time.Time{})
+	}
+	trans.SendHeartbeat(rt.heartbeat)
+	return trans, nil
+}
+
+// deadliner is implemented by net.Conn, to bound the handshake.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+func (rt *ReconnectingTransport) setdisconnected() {
+	rt.mu.Lock()
+	rt.trans, rt.connch = nil, make(chan struct{})
+	rt.mu.Unlock()
+}
+
+func (rt *ReconnectingTransport) notify(
+	state ConnState, trans *Transport, err error) {
+
+	infof("%v %v\n", rt.name, state)
+	if rt.statecb != nil {
+		rt.statecb(state, trans, err)
+	}
+}
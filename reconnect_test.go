@@ -0,0 +1,252 @@
+package gofast
+
+import "testing"
+import "fmt"
+import "net"
+import "time"
+import "sync"
+import "reflect"
+
+func TestReconnect(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newEchoServer(addr, nil)
+
+	var mu sync.Mutex
+	states := []ConnState{}
+	connch := make(chan *Transport, 10)
+
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["reconnect.minbackoff"] = 10
+	setts["reconnect.maxbackoff"] = 50
+	rt := NewReconnectingTransport("client", TCPDialer(addr), &ver, setts)
+	rt.SubscribeMessage(&testMessage{}, nil)
+	rt.StateCallback(func(state ConnState, trans *Transport, err error) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+		if state == StateConnected {
+			connch <- trans
+		}
+	})
+	rt.Start()
+
+	// first connection.
+	transc := <-connch
+	transv := <-serverch
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := rt.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	// server drops the connection, client shall redial.
+	transv.Close()
+	transc2 := <-connch
+	transv = <-serverch
+	if transc2 == transc {
+		t.Errorf("expected a new transport")
+	} else if transc2.Name() == transc.Name() {
+		t.Errorf("expected a new name, got %v", transc2.Name())
+	}
+	resp = &testMessage{}
+	if err := rt.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	rt.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ref := []ConnState{
+		StateConnecting, StateConnected, StateDisconnected,
+		StateConnecting, StateConnected, StateClosed,
+	}
+	mu.Lock()
+	if !reflect.DeepEqual(states, ref) {
+		t.Errorf("expected %v, got %v", ref, states)
+	}
+	mu.Unlock()
+	if rt.Transport() != nil {
+		t.Errorf("expected nil transport after close")
+	}
+
+	lis.Close()
+	transv.Close()
+}
+
+func TestReconnectFail(t *testing.T) {
+	addr := <-testBindAddrs
+
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["reconnect.minbackoff"] = 10
+	setts["reconnect.maxbackoff"] = 50
+	rt := NewReconnectingTransport("client", TCPDialer(addr), &ver, setts)
+	rt.SubscribeMessage(&testMessage{}, nil)
+	rt.Start()
+
+	msg := &testMessage{1234}
+	if err := rt.Request(msg, true, &testMessage{}); err != ErrDisconnected {
+		t.Errorf("expected %v, got %v", ErrDisconnected, err)
+	}
+	if err := rt.Post(msg, true); err != ErrDisconnected {
+		t.Errorf("expected %v, got %v", ErrDisconnected, err)
+	}
+	if call := <-rt.Go(msg, &testMessage{}, nil).Done; call.Error != ErrDisconnected {
+		t.Errorf("expected %v, got %v", ErrDisconnected, call.Error)
+	}
+	rt.Close()
+}
+
+func TestReconnectQueue(t *testing.T) {
+	addr := <-testBindAddrs
+
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["reconnect.policy"] = "queue"
+	setts["reconnect.minbackoff"] = 10
+	setts["reconnect.maxbackoff"] = 50
+	rt := NewReconnectingTransport("client", TCPDialer(addr), &ver, setts)
+	rt.SubscribeMessage(&testMessage{}, nil)
+	rt.Start()
+
+	msg := &testMessage{1234}
+	call := rt.Go(msg, &testMessage{}, nil)
+	errch := make(chan error, 1)
+	go func() {
+		resp := &testMessage{}
+		err := rt.Request(msg, true, resp)
+		if err == nil && !reflect.DeepEqual(resp, msg) {
+			err = fmt.Errorf("expected %v, got %v", msg, resp)
+		}
+		errch <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	lis, serverch := newEchoServer(addr, nil)
+	transv := <-serverch
+
+	if err := <-errch; err != nil {
+		t.Error(err)
+	}
+	if call = <-call.Done; call.Error != nil {
+		t.Error(call.Error)
+	} else if !reflect.DeepEqual(call.Response, msg) {
+		t.Errorf("expected %v, got %v", msg, call.Response)
+	}
+
+	rt.Close()
+	lis.Close()
+	transv.Close()
+}
+
+func TestReconnectHandshake(t *testing.T) {
+	addr := <-testBindAddrs
+	// remote accepts connections but never completes the handshake.
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptch := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				close(acceptch)
+				return
+			}
+			acceptch <- conn
+		}
+	}()
+
+	ver := testVersion(1)
+	statech := make(chan ConnState, 10)
+	newrt := func(timeout int64) *ReconnectingTransport {
+		setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+		setts["reconnect.minbackoff"] = 1000
+		setts["reconnect.maxbackoff"] = 1000
+		setts["reconnect.handshaketimeout"] = timeout
+		rt := NewReconnectingTransport("client", TCPDialer(addr), &ver, setts)
+		rt.StateCallback(func(state ConnState, trans *Transport, err error) {
+			statech <- state
+		})
+		return rt
+	}
+	waitstate := func(ref ConnState) {
+		select {
+		case state := <-statech:
+			if state != ref {
+				t.Errorf("expected %v, got %v", ref, state)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %v", ref)
+		}
+	}
+
+	// handshake shall timeout.
+	rt := newrt(100)
+	rt.Start()
+	waitstate(StateConnecting)
+	conn := <-acceptch
+	waitstate(StateDisconnected)
+	rt.Close()
+	waitstate(StateClosed)
+	conn.Close()
+
+	// Close() shall interrupt the handshake.
+	rt = newrt(0)
+	rt.Start()
+	waitstate(StateConnecting)
+	conn = <-acceptch
+	rt.Close()
+	waitstate(StateDisconnected)
+	waitstate(StateClosed)
+	conn.Close()
+
+	lis.Close()
+}
+
+func newEchoServer(
+	addr string, auth Authenticator) (net.Listener, chan *Transport) {
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(fmt.Errorf("listen failed %v", err))
+	}
+	ch := make(chan *Transport, 10)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			ver := testVersion(1)
+			setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+			name := fmt.Sprintf("server-%v-%v", addr, i)
+			trans, err := NewTransport(name, conn, &ver, setts)
+			if err != nil {
+				panic(err)
+			}
+			trans.SubscribeMessage(
+				&testMessage{},
+				func(s *Stream, rxmsg BinMessage) StreamCallback {
+					var m testMessage
+
+					m.Decode(rxmsg.Data)
+					s.Response(&m, true)
+					return nil
+				})
+			if auth != nil {
+				trans.Authenticate(auth)
+			}
+			if err := trans.Handshake(); err != nil {
+				continue
+			}
+			ch <- trans
+		}
+	}()
+	return lis, ch
+}
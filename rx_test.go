@@ -38,7 +38,7 @@ func TestUnmessage(t *testing.T) {
 	// unmessage
 	var wai whoamiMsg
 	ref := newWhoami(transc)
-	bmsg := transc.unmessage(100, bs)
+	bmsg, _ := transc.unmessage(100, bs)
 	wai.transport = transc
 	wai.version = transc.version
 	wai.Decode(bmsg.Data)
@@ -0,0 +1,341 @@
+package gofast
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "context"
+import "sync/atomic"
+
+import s "github.com/bnclabs/gosettings"
+
+// Mux is a table of messages and their handlers, that can be applied
+// to any number of transports. Mux shall be fully populated before it
+// is applied to a transport.
+type Mux struct {
+	subs     []subscription
+	defaulth RequestCallback
+	auth     Authenticator
+}
+
+type subscription struct {
+	msg     Message
+	handler RequestCallback
+}
+
+// NewMux create an empty table of message handlers.
+func NewMux() *Mux {
+	return &Mux{subs: make([]subscription, 0, 8)}
+}
+
+// SubscribeMessage that shall be subscribed on every transport this mux
+// is applied to, refer to Transport.SubscribeMessage.
+func (mux *Mux) SubscribeMessage(msg Message, handler RequestCallback) *Mux {
+	mux.subs = append(mux.subs, subscription{msg: msg, handler: handler})
+	return mux
+}
+
+// DefaultHandler that shall be set on every transport this mux is
+// applied to, refer to Transport.DefaultHandler.
+func (mux *Mux) DefaultHandler(handler RequestCallback) *Mux {
+	mux.defaulth = handler
+	return mux
+}
+
+// Authenticate every transport this mux is applied to, refer to
+// Transport.Authenticate.
+func (mux *Mux) Authenticate(auth Authenticator) *Mux {
+	mux.auth = auth
+	return mux
+}
+
+// Apply subscribed messages, default handler and authenticator on
+// transport, shall be called before Handshake.
+func (mux *Mux) Apply(trans *Transport) *Transport {
+	for _, sub := range mux.subs {
+		trans.SubscribeMessage(sub.msg, sub.handler)
+	}
+	if mux.defaulth != nil {
+		trans.DefaultHandler(mux.defaulth)
+	}
+	if mux.auth != nil {
+		trans.Authenticate(mux.auth)
+	}
+	return trans
+}
+
+// Server accept connections from listener and create a transport for
+// each connection, with messages subscribed from Mux. Refer to
+// DefaultSettings for "server.*" parameters, rest of the Settings are
+// passed to NewTransport.
+type Server struct {
+	// Name prefix for transports, suffixed with a sequence number. If
+	// empty, listener's address is used.
+	Name     string
+	Settings s.Settings
+	Version  Version
+	Mux      *Mux
+	// Setup optional, called for every new transport after applying Mux
+	// and before Handshake.
+	Setup func(trans *Transport)
+
+	mu         sync.Mutex
+	seqno      uint64
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{} // connections yet to handshake.
+	transports map[*Transport]struct{}
+	doneonce   sync.Once
+	donech     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Serve accept connections from lis till the server is closed or the
+// listener fails. Handshake for every accepted connection is done
+// concurrently. Serve always returns a non-nil error, after Shutdown()
+// or Close() it shall return ErrServerClosed.
+func (srv *Server) Serve(lis net.Listener) error {
+	// missing settings, or nil Settings, shall default to DefaultSettings.
+	setts := DefaultSettings(1000, 5000).Mixin(srv.Settings)
+	maxconns := setts.Int64("server.maxconns")
+	prefix := srv.Name
+	if prefix == "" {
+		prefix = lis.Addr().String()
+	}
+
+	if !srv.track(lis, true) {
+		lis.Close()
+		return ErrServerClosed
+	}
+	defer srv.track(lis, false)
+
+	var sema chan struct{}
+	if maxconns > 0 {
+		sema = make(chan struct{}, maxconns)
+	}
+	donech := srv.getdonech()
+	for {
+		if sema != nil { // wait for a free slot.
+			select {
+			case sema <- struct{}{}:
+			case <-donech:
+				return ErrServerClosed
+			}
+		}
+		conn, err := lis.Accept()
+		if err != nil {
+			select {
+			case <-donech:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				warnf("server %v accept: %v\n", prefix, err)
+				time.Sleep(10 * time.Millisecond)
+				if sema != nil {
+					<-sema
+				}
+				continue
+			}
+			return err
+		}
+		srv.mu.Lock()
+		select {
+		case <-donech:
+			srv.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		default:
+		}
+		if srv.conns == nil {
+			srv.conns = make(map[net.Conn]struct{})
+		}
+		srv.conns[conn] = struct{}{}
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+
+		seqno := atomic.AddUint64(&srv.seqno, 1)
+		name := fmt.Sprintf("%v-%v", prefix, seqno)
+		go srv.handshake(name, conn, setts, sema)
+	}
+}
+
+// Transports return a snapshot of live transports.
+func (srv *Server) Transports() []*Transport {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	transs := make([]*Transport, 0, len(srv.transports))
+	for trans := range srv.transports {
+		transs = append(transs, trans)
+	}
+	return transs
+}
+
+// Shutdown the server gracefully, stop accepting new connections, close
+// connections yet to complete their handshake and shutdown all live
+// transports, refer to Transport.Shutdown. If ctx is done before all
+// transports are drained, remaining transports are closed and ctx's
+// error is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.closelisteners()
+	srv.closeconns()
+	waitch := make(chan struct{})
+	go func() {
+		srv.wg.Wait() // wait for on-going handshakes to fail.
+		close(waitch)
+	}()
+	select {
+	case <-waitch:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var wg sync.WaitGroup
+	errch := make(chan error, 1)
+	for _, trans := range srv.Transports() {
+		wg.Add(1)
+		go func(trans *Transport) {
+			defer wg.Done()
+			if err := trans.Shutdown(ctx); err != nil {
+				select {
+				case errch <- err:
+				default:
+				}
+			}
+		}(trans)
+	}
+	wg.Wait()
+	select {
+	case err := <-errch:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// Close the server immediately, stop accepting new connections and
+// close all connections and live transports.
+func (srv *Server) Close() error {
+	srv.closelisteners()
+	srv.closeconns()
+	srv.wg.Wait() // wait for on-going handshakes to fail.
+	for _, trans := range srv.Transports() {
+		trans.Close()
+	}
+	return nil
+}
+
+func (srv *Server) handshake(
+	name string, conn net.Conn, setts s.Settings, sema chan struct{}) {
+
+	defer srv.wg.Done()
+	release := func() {
+		if sema != nil {
+			<-sema
+		}
+	}
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+	}()
+
+	timeout := time.Duration(setts.Int64("server.handshaketimeout"))
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout * time.Millisecond))
+	}
+
+	trans, err := NewTransport(name, conn, srv.Version, setts)
+	if err != nil {
+		errorf("server %v: %v\n", name, err)
+		conn.Close()
+		release()
+		return
+	}
+	if srv.Mux != nil {
+		srv.Mux.Apply(trans)
+	}
+	if srv.Setup != nil {
+		srv.Setup(trans)
+	}
+	if err := trans.Handshake(); err != nil {
+		errorf("server %v handshake: %v\n", name, err)
+		trans.Close()
+		release()
+		return
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Time{})
+	}
+
+	srv.mu.Lock()
+	select {
+	case <-srv.getdonech(): // server closed while handshaking.
+		srv.mu.Unlock()
+		trans.Close()
+		release()
+		return
+	default:
+	}
+	if srv.transports == nil {
+		srv.transports = make(map[*Transport]struct{})
+	}
+	srv.transports[trans] = struct{}{}
+	srv.mu.Unlock()
+
+	go func() {
+		<-trans.killch
+		srv.mu.Lock()
+		delete(srv.transports, trans)
+		srv.mu.Unlock()
+		release()
+	}()
+}
+
+func (srv *Server) track(lis net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	if add {
+		select {
+		case <-srv.getdonech():
+			return false
+		default:
+		}
+		srv.listeners[lis] = struct{}{}
+	} else {
+		delete(srv.listeners, lis)
+	}
+	return true
+}
+
+func (srv *Server) closelisteners() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	donech := srv.getdonech()
+	select {
+	case <-donech:
+	default:
+		close(donech)
+	}
+	for lis := range srv.listeners {
+		lis.Close()
+		delete(srv.listeners, lis)
+	}
+}
+
+// closeconns shall close connections yet to complete their handshake,
+// failing the handshake.
+func (srv *Server) closeconns() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+}
+
+func (srv *Server) getdonech() chan struct{} {
+	srv.doneonce.Do(func() { srv.donech = make(chan struct{}) })
+	return srv.donech
+}
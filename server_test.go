@@ -0,0 +1,192 @@
+package gofast
+
+import "testing"
+import "context"
+import "reflect"
+import "net"
+import "time"
+
+func TestServer(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewMux().SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+	ver := testVersion(1)
+	srv := &Server{
+		Name:     "server",
+		Settings: newsetts(TagOpaqueStart, TagOpaqueStart+10),
+		Version:  &ver,
+		Mux:      mux,
+	}
+	errch := make(chan error, 1)
+	go func() { errch <- srv.Serve(lis) }()
+
+	transcs := []*Transport{}
+	for _, name := range []string{"client1", "client2"} {
+		transc := newClient(name, addr, "")
+		transc.SubscribeMessage(&testMessage{}, nil)
+		if err := transc.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		transcs = append(transcs, transc)
+	}
+	for _, transc := range transcs {
+		msg, resp := &testMessage{1234}, &testMessage{}
+		if err := transc.Request(msg, true, resp); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(resp, msg) {
+			t.Errorf("expected %v, got %v", msg, resp)
+		}
+	}
+	if n := len(srv.Transports()); n != 2 {
+		t.Errorf("expected %v, got %v", 2, n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error(err)
+	} else if err := <-errch; err != ErrServerClosed {
+		t.Errorf("expected %v, got %v", ErrServerClosed, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(srv.Transports()); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	for _, transc := range transcs {
+		if code, _, ok := transc.PeerGoaway(); !ok {
+			t.Errorf("expected goaway from server")
+		} else if code != GoawayShutdown {
+			t.Errorf("expected %v, got %v", GoawayShutdown, code)
+		}
+		transc.Close()
+	}
+	if err := srv.Serve(lis); err != ErrServerClosed {
+		t.Errorf("expected %v, got %v", ErrServerClosed, err)
+	}
+}
+
+func TestServerMaxconns(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["server.maxconns"] = 1
+	srv := &Server{Settings: setts, Version: &ver, Mux: NewMux()}
+	go srv.Serve(lis)
+
+	transc1 := newClient("client1", addr, "")
+	if err := transc1.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transc2 := newClient("client2", addr, "")
+	donech := make(chan error, 1)
+	go func() { donech <- transc2.Handshake() }()
+
+	select {
+	case <-donech:
+		t.Errorf("unexpected handshake beyond server.maxconns")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if n := len(srv.Transports()); n != 1 {
+		t.Errorf("expected %v, got %v", 1, n)
+	}
+
+	transc1.Close() // shall free a slot for client2.
+	select {
+	case err := <-donech:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected handshake after a slot is freed")
+	}
+
+	srv.Close()
+	transc2.Close()
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["server.maxconns"] = 1
+	setts["server.handshaketimeout"] = 200
+	srv := &Server{Settings: setts, Version: &ver, Mux: NewMux()}
+	go srv.Serve(lis)
+
+	idle, err := net.Dial("tcp", addr) // never handshakes.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// idle connection shall free its slot after handshake timeout.
+	transc := newClient("client", addr, "")
+	donech := make(chan error, 1)
+	go func() { donech <- transc.Handshake() }()
+	select {
+	case err := <-donech:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected handshake after idle connection timed out")
+	}
+	// deadline shall be cleared after handshake.
+	time.Sleep(300 * time.Millisecond)
+	if _, err := transc.Ping("server"); err != nil {
+		t.Error(err)
+	}
+
+	srv.Close()
+	transc.Close()
+}
+
+func TestServerShutdownIdle(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver := testVersion(1)
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["server.handshaketimeout"] = 0
+	srv := &Server{Settings: setts, Version: &ver, Mux: NewMux()}
+	go srv.Serve(lis)
+
+	idle, err := net.Dial("tcp", addr) // never handshakes.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	tm := 500 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), tm)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error(err)
+	} else if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %v", took)
+	}
+}
@@ -1,20 +1,40 @@
 package gofast
 
+import "fmt"
+import "context"
 import "sync/atomic"
 
+const (
+	streamOpen uint32 = iota
+	streamClosed
+	streamCancelled
+)
+
 // Stream for a newly started stream on the transport. Sender can
 // initiate a new stream by calling Transport.Stream() API, while
 // receiver will return a Stream instance via RequestCallback.
 type Stream struct {
-	transport         *Transport
-	rxcallb           StreamCallback
-	opaque            uint64
-	remote            bool
-	out, data, tagout []byte
+	transport *Transport
+	rxcallb   StreamCallback
+	opaque    uint64
+	remote    bool
+	ctx       context.Context // only for local streams.
+	closech   chan struct{}   // stop watching ctx on Close().
+	gen       uint64          // bumped every time stream is reused.
+	rxresp    bool            // owned by syncRx, response received.
+	oneshot   bool            // syncRx to release after response.
+	txstate   uint32          // streamOpen, streamClosed ...
+	windowed  bool            // remote expects flow control.
+	credits   int64           // messages we can stream to remote.
+	creditch  chan struct{}   // wakeup Stream() waiting on credits.
+	rxdone    uint32          // set by syncRx, remote is done.
+	rxcount   uint64          // owned by syncRx, credits to grant.
+	lane      Lane            // all packets on stream are sent in lane.
 }
 
-// constructor used for remote streams.
-func (t *Transport) newremotestream(opaque uint64) *Stream {
+// constructor used for remote streams, id is the message-id of remote's
+// request or stream-start.
+func (t *Transport) newremotestream(opaque, id uint64) *Stream {
 	stream := t.fromrxstrm()
 
 	//TODO: Issue #2, remove or prevent value escape to heap
@@ -23,19 +43,99 @@ func (t *Transport) newremotestream(opaque uint64) *Stream {
 
 	// reset all fields (it is coming from a pool)
 	stream.transport, stream.remote, stream.opaque = t, true, opaque
-	stream.rxcallb = nil
+	stream.rxcallb, stream.txstate = nil, streamOpen
+	stream.lane = t.idlane(id)
+	t.resetwindow(stream)
+	atomic.AddUint64(&stream.gen, 1)
+	atomic.AddInt64(&t.nactive, 1)
 	return stream
 }
 
-// called only be tx.
-func (t *Transport) getlocalstream(tellrx bool, rxcallb StreamCallback) *Stream {
-	stream := <-t.pStrms
-	stream.rxcallb = rxcallb
+// called only be tx, lane is picked for post, request or stream-start.
+// Wait for a free opaque till ctx is done or transport is closed, opaques
+// cancelled locally are reclaimed only after remote acknowledges.
+func (t *Transport) getlocalstream(
+	ctx context.Context, lane Lane, tellrx bool,
+	rxcallb StreamCallback) (*Stream, error) {
+
+	var stream *Stream
+	select {
+	case stream = <-t.pStrms:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.killch:
+		return nil, fmt.Errorf("transport closed")
+	}
+	stream.lane = lane
+	stream.rxcallb, stream.ctx, stream.rxresp = rxcallb, nil, false
+	stream.closech, stream.oneshot = nil, false
+	stream.txstate = streamOpen
+	t.resetwindow(stream)
 	atomic.StoreUint64(&stream.opaque, stream.opaque)
+	atomic.AddUint64(&stream.gen, 1)
 	if tellrx {
 		t.putch(t.rxch, rxpacket{stream: stream})
 	}
-	return stream
+	return stream, nil
+}
+
+// newctrlstream shall create a scratch stream, used to send control
+// messages on behalf of other streams.
+func (t *Transport) newctrlstream() *Stream {
+	return &Stream{transport: t, lane: LaneBulk}
+}
+
+// resetwindow shall initialize stream's credits with window advertised
+// by remote.
+func (t *Transport) resetwindow(stream *Stream) {
+	window := atomic.LoadInt64(&t.peerwindow)
+	stream.windowed, stream.rxcount = window > 0, 0
+	atomic.StoreInt64(&stream.credits, window)
+	atomic.StoreUint32(&stream.rxdone, 0)
+	if stream.creditch == nil {
+		stream.creditch = make(chan struct{}, 1)
+	}
+	select {
+	case <-stream.creditch: // drain stale wakeup
+	default:
+	}
+}
+
+// rxclosed shall be called by syncRx when remote is done with the
+// stream, wakeup Stream() if it is waiting for credits.
+func (t *Transport) rxclosed(stream *Stream) {
+	atomic.StoreUint32(&stream.rxdone, 1)
+	stream.wakeup()
+}
+
+// rxcredits shall be called by syncRx for every window update from
+// remote.
+func (t *Transport) rxcredits(stream *Stream, msg BinMessage) {
+	var m windowMsg
+	m.Decode(msg.Data)
+	atomic.AddInt64(&stream.credits, int64(m.credits))
+	stream.wakeup()
+}
+
+// rxconsumed shall be called by syncRx for every stream message
+// handled by StreamCallback, grant credits back to remote once half
+// the window is consumed.
+func (t *Transport) rxconsumed(stream *Stream, ctrl *Stream) {
+	if t.window == 0 || t.autogrant == false {
+		return
+	}
+	stream.rxcount++
+	if stream.rxcount < (t.window/2)+(t.window%2) {
+		return
+	}
+	ctrl.opaque, ctrl.lane = stream.opaque, stream.lane
+	locked := t.txlock()
+	err := t.txmsg(t.stream, newWindow(stream.rxcount), ctrl, t.txasync, true)
+	t.txunlock(locked)
+	if err != nil {
+		errorf("%v ##%d window: %v\n", t.logprefix, stream.opaque, err)
+	}
+	stream.rxcount = 0
 }
 
 func (t *Transport) putstream(opaque uint64, stream *Stream, tellrx bool) {
@@ -57,23 +157,168 @@ func (t *Transport) putstream(opaque uint64, stream *Stream, tellrx bool) {
 	}
 }
 
+// dropstream shall tell syncRx to forget a local stream that could not
+// be transmitted, and return it to the pool. Stream's callback is reset
+// by syncRx, which might not have started tracking the stream yet.
+func (t *Transport) dropstream(stream *Stream) {
+	t.putch(t.rxch, rxpacket{stream: stream, finish: true})
+}
+
+// cancelstream shall tell syncRx that the local side has given up on
+// the request or stream, any late response or stream message from
+// remote shall be dropped and the opaque reclaimed once remote is done.
+func (t *Transport) cancelstream(stream *Stream, gen uint64, request bool) {
+	rxpkt := rxpacket{stream: stream, cancel: true, gen: gen, request: request}
+	t.putch(t.rxch, rxpkt)
+}
+
+// txcancel shall send a cancel for stream's opaque to remote.
+func (t *Transport) txcancel(stream *Stream) {
+	var scratch [256]byte
+	locked := t.txlock()
+	n, err := t.cancel(stream, scratch[:])
+	if err == nil {
+		err = t.txasync(scratch[:n], stream.lane, true /*flush*/)
+	}
+	t.txunlock(locked)
+	if err != nil {
+		errorf("%v ##%d cancel: %v\n", t.logprefix, stream.opaque, err)
+	}
+}
+
 // Response to a request, to batch the response pass flush as false.
+// If remote has cancelled the request, ErrStreamCancelled is returned.
+// Response is sent in the lane set for request's message id, unless msg
+// is wrapped using WithLane().
 func (s *Stream) Response(msg Message, flush bool) error {
 	defer s.transport.pRxstrm.Put(s)
-	n := s.transport.response(msg, s, s.out)
-	return s.transport.txasync(s.out[:n], flush)
+	if !s.txend(streamClosed) {
+		return ErrStreamCancelled
+	}
+	if lmsg, ok := msg.(*laneMsg); ok {
+		s.lane = lmsg.lane
+	}
+	t := s.transport
+	defer t.txunlock(t.txlock())
+	return t.txmsg(t.response, msg, s, t.txasync, flush)
+}
+
+// ResponseError to a request, remote's Request() call shall return this
+// error as *RemoteError. To batch the response pass flush as false.
+// Reserved codes, ErrorCodeGoaway and ErrorCodeAuth, are remapped to
+// ErrorCodeUnknown, text is sent as is.
+func (s *Stream) ResponseError(err error, flush bool) error {
+	return s.Response(newError(err), flush)
 }
 
 // Stream a single message, to batch the message pass flush as false.
+// If remote has cancelled the stream, ErrStreamCancelled is returned.
+// If remote has advertised a stream window and has not granted enough
+// credits, block till credits are granted, or return ErrWindowExhausted
+// if "stream.block" is false. If msg is larger than "maxmessagesize",
+// ErrMessageTooLarge is returned. Messages are sent in the lane the
+// stream was started with, lane set by WithLane() is ignored.
 func (s *Stream) Stream(msg Message, flush bool) (err error) {
-	n := s.transport.stream(msg, s, s.out)
-	return s.transport.txasync(s.out[:n], flush)
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return s.ctx.Err()
+	} else if atomic.LoadUint32(&s.txstate) == streamCancelled {
+		return ErrStreamCancelled
+	} else if err = s.transport.oversized(msg); err != nil {
+		return err
+	} else if err = s.getcredit(); err != nil {
+		return err
+	}
+	t := s.transport
+	defer t.txunlock(t.txlock())
+	return t.txmsg(t.stream, msg, s, t.txasync, flush)
+}
+
+// Grant credits to remote, to stream n more messages on this stream.
+// Applications need to call this only if "stream.autogrant" is false,
+// after consuming messages received via StreamCallback.
+func (s *Stream) Grant(n uint64) error {
+	if atomic.LoadUint32(&s.txstate) == streamCancelled {
+		return ErrStreamCancelled
+	}
+	t := s.transport
+	defer t.txunlock(t.txlock())
+	return t.txmsg(t.stream, newWindow(n), s, t.txasync, true /*flush*/)
 }
 
-// Close this stream.
+func (s *Stream) getcredit() error {
+	if s.windowed == false {
+		return nil
+	}
+	var donech <-chan struct{}
+	if s.ctx != nil {
+		donech = s.ctx.Done()
+	}
+	for {
+		if atomic.AddInt64(&s.credits, -1) >= 0 {
+			return nil
+		}
+		atomic.AddInt64(&s.credits, 1)
+		if atomic.LoadUint32(&s.rxdone) == 1 {
+			return nil // remote is done, message shall be dropped.
+		} else if s.transport.winblock == false {
+			return ErrWindowExhausted
+		}
+		select {
+		case <-s.creditch:
+		case <-donech:
+			return s.ctx.Err()
+		case <-s.transport.killch:
+			return fmt.Errorf("transport closed")
+		}
+	}
+}
+
+func (s *Stream) wakeup() {
+	select {
+	case s.creditch <- struct{}{}:
+	default:
+	}
+}
+
+// Close this stream. If stream was started with a context that is
+// already done, or if remote has cancelled the stream, or if stream is
+// already closed, this is a no-op.
 func (s *Stream) Close() error {
-	n := s.transport.finish(s, s.out)
-	return s.transport.txasync(s.out[:n], true /*flush*/)
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return nil
+	} else if !s.txend(streamClosed) {
+		return nil
+	} else if s.closech != nil {
+		close(s.closech)
+		s.closech = nil
+	}
+	var scratch [256]byte
+	var release *Stream
+	if s.remote == false && s.rxcallb == nil { // not tracked by syncRx.
+		release = s // after finish is written.
+	}
+	t := s.transport
+	locked := t.txlock()
+	n, err := t.finish(s, scratch[:])
+	if err == nil {
+		err = t.txrelease(scratch[:n], s.lane, true /*flush*/, release)
+	}
+	t.txunlock(locked)
+	if err != nil && release != nil {
+		t.pStrms <- s
+	}
+	return err
+}
+
+// txend shall move stream's tx state from open to state, returns false
+// if stream is already closed or cancelled.
+func (s *Stream) txend(state uint32) bool {
+	if !atomic.CompareAndSwapUint32(&s.txstate, streamOpen, state) {
+		return false
+	} else if s.remote {
+		atomic.AddInt64(&s.transport.nactive, -1)
+	}
+	return true
 }
 
 // Transport return the underlying transport carrying this stream.
@@ -0,0 +1,97 @@
+package gofast
+
+import "strings"
+
+import s "github.com/bnclabs/gosettings"
+
+// TagFn transforms payload `in` into `out` and return the number of
+// bytes written into `out`. Encoders can return ZERO to skip the tag
+// for a packet, except for integrity tags like "hmac" and "aesgcm", and
+// shall return -1 if payload cannot be encoded. Decoders shall return -1
+// if payload fails integrity check or cannot be decoded, such packets
+// are dropped and counted as "n_corrupt".
+type TagFn func(in, out []byte) int
+
+// TagFactory shall return a new pair of encoder and decoder for
+// transport, configured with settings. Encoder can be called
+// concurrently for different streams, decoder is always called from
+// the same routine.
+type TagFactory func(t *Transport, setts s.Settings) (enc, dec TagFn)
+
+// TagCustomStart is the first tag-id available for custom tags,
+// tag-ids below this are reserved by CBOR and gofast, and tag-ids from
+// TagOpaqueStart onwards are used as opaque values for streams.
+const TagCustomStart = 64
+
+// TagOptions describe how transport shall apply a registered tag.
+type TagOptions struct {
+	// Stateful tags carry state from one packet to the next, like
+	// streaming compression or nonce counters. Packets are encoded in
+	// the same order they are transmitted, and priority lanes are
+	// disabled for transports using them.
+	Stateful bool
+	// Integrity tags, like "hmac" and "aesgcm", once negotiated are
+	// never skipped by encoder, and packets received from remote
+	// without them are dropped and counted as "n_corrupt".
+	Integrity bool
+}
+
+type tagentry struct {
+	id      uint64
+	factory TagFactory
+	// stateful tags carry state from one packet to the next, packets
+	// shall be encoded in the same order they are transmitted.
+	stateful bool
+	// integrity tags, once negotiated, are never skipped by encoder and
+	// are required on every packet received from remote.
+	integrity bool
+}
+
+// name -> tagentry, shall only be updated at init time.
+var tagFactory = make(map[string]tagentry)
+
+// RegisterTag to add a custom transform, like compression, encryption
+// or framing, to the tag pipeline. Once registered, tag can be enabled
+// by its `name` in "tags" settings. `id` is carried on the wire and
+// must be unique and within [TagCustomStart, TagOpaqueStart). Shall be
+// called before creating transports, typically from init(). Use opts
+// to mark the tag as stateful or integrity, refer to TagOptions.
+func RegisterTag(
+	name string, id uint64, factory TagFactory, opts TagOptions) error {
+
+	if name == "" || strings.ContainsAny(name, ", \n\t\r") {
+		return ErrorInvalidTag
+	} else if factory == nil {
+		return ErrorInvalidTag
+	} else if id < TagCustomStart || id >= TagOpaqueStart {
+		return ErrTagReserved
+	}
+	return registertag(name, id, factory, opts)
+}
+
+func registertag(
+	name string, id uint64, factory TagFactory, opts TagOptions) error {
+
+	for xname, entry := range tagFactory {
+		if xname == name || entry.id == id {
+			return ErrTagDuplicate
+		}
+	}
+	tagFactory[name] = tagentry{
+		id: id, factory: factory,
+		stateful: opts.Stateful, integrity: opts.Integrity,
+	}
+	return nil
+}
+
+// maketag return tag-id, encoder and decoder for tag `name`.
+func maketag(
+	name string, t *Transport, setts s.Settings) (uint64, TagFn, TagFn, bool) {
+
+	entry, ok := tagFactory[name]
+	if !ok {
+		return 0, nil, nil, false
+	}
+	enc, dec := entry.factory(t, setts)
+	return entry.id, enc, dec, true
+}
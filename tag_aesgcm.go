@@ -0,0 +1,176 @@
+package gofast
+
+import "fmt"
+import "strings"
+import "strconv"
+import "sync/atomic"
+import "crypto/aes"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "crypto/cipher"
+import "encoding/hex"
+import "encoding/binary"
+
+import s "github.com/bnclabs/gosettings"
+
+// aesgcm payload is, | keyid (1) | nonce (12) | ciphertext + gcm-tag |
+// nonce is a random 4-byte prefix, picked for every direction, followed
+// by a 8-byte packet counter. Packets are encrypted with a subkey
+// derived from the pre-shared key and salts exchanged in whoami, unique
+// for every connection and direction, hence the counter can start from
+// 1 for every transport. Tag is stateful, packets are transmitted in the
+// order they are encrypted and decoder rejects replayed or old counters.
+const aesgcmNonce = 12
+const aesgcmOverhead = 1 + aesgcmNonce + 16
+
+func makeAESGCM(t *Transport, settings s.Settings) (TagFn, TagFn) {
+	keys, keyid, err := aesgcmKeys(settings)
+	var prefix [4]byte
+	if _, err := rand.Read(prefix[:]); err != nil {
+		panic(fmt.Errorf("error seeding aesgcm nonce: %v", err))
+	}
+	var counter uint64
+
+	// encoder is created after negotiation, when remote's salt is known.
+	var txaead cipher.AEAD
+	if err == nil {
+		localsalt, peersalt := aesgcmSalts(t)
+		txaead, err = aesgcmSubkey(keys[keyid], localsalt, peersalt)
+	}
+	enc := func(in, out []byte) int {
+		if err != nil {
+			aesgcmerror(t, "encrypt", "%v", err)
+			return -1
+		} else if len(in)+aesgcmOverhead > len(out) {
+			aesgcmerror(t, "encrypt", "insufficient buffersize %v", len(out))
+			return -1
+		}
+		out[0] = keyid
+		nonce := out[1 : 1+aesgcmNonce]
+		copy(nonce, prefix[:])
+		binary.BigEndian.PutUint64(nonce[4:], atomic.AddUint64(&counter, 1))
+		sealed := out[1+aesgcmNonce : 1+aesgcmNonce]
+		sealed = txaead.Seal(sealed, nonce, in, nil)
+		return 1 + aesgcmNonce + len(sealed)
+	}
+
+	// decoder is called only from doRx() routine, subkeys are derived
+	// on first use, after remote's salt is known.
+	rxaeads, last := make(map[byte]cipher.AEAD), uint64(0)
+	dec := func(in, out []byte) int {
+		if len(in) < aesgcmOverhead {
+			aesgcmerror(t, "decrypt", "insufficient payload %v", len(in))
+			return -1
+		}
+		aead, ok := rxaeads[in[0]]
+		if !ok {
+			key, ok := keys[in[0]]
+			if !ok {
+				aesgcmerror(t, "decrypt", "unknown keyid %v", in[0])
+				return -1
+			}
+			localsalt, peersalt := aesgcmSalts(t)
+			if t != nil && peersalt == nil {
+				aesgcmerror(t, "decrypt", "remote salt not known")
+				return -1
+			}
+			var err error
+			if aead, err = aesgcmSubkey(key, peersalt, localsalt); err != nil {
+				aesgcmerror(t, "decrypt", "keyid %v: %v", in[0], err)
+				return -1
+			}
+			rxaeads[in[0]] = aead
+		}
+		nonce, sealed := in[1:1+aesgcmNonce], in[1+aesgcmNonce:]
+		if len(sealed)-aead.Overhead() > len(out) {
+			aesgcmerror(t, "decrypt", "insufficient buffersize %v", len(sealed))
+			return -1
+		}
+		plain, err := aead.Open(out[:0], nonce, sealed, nil)
+		if err != nil {
+			aesgcmerror(t, "decrypt", "keyid %v: %v", in[0], err)
+			return -1
+		}
+		// counter is checked only after authentication.
+		ctr := binary.BigEndian.Uint64(nonce[4:])
+		if ctr <= last {
+			aesgcmerror(t, "decrypt", "replayed counter %v", ctr)
+			return -1
+		}
+		last = ctr
+		return len(plain)
+	}
+	return enc, dec
+}
+
+// aesgcmKeys parse "aesgcm.keys", as comma separated list of
+// <keyid>:<hex-key>, and "aesgcm.keyid" used for encryption.
+func aesgcmKeys(settings s.Settings) (map[byte][]byte, byte, error) {
+	keys := make(map[byte][]byte)
+	for _, item := range strings.Split(settings.String("aesgcm.keys"), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, 0, fmt.Errorf("invalid aesgcm key %q", item)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 8)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid aesgcm keyid %q", parts[0])
+		}
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid aesgcm key for %v: %v", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, 0, fmt.Errorf("invalid aesgcm key for %v: %v", id, err)
+		}
+		keys[byte(id)] = key
+	}
+	keyid := settings.Int64("aesgcm.keyid")
+	if _, ok := keys[byte(keyid)]; !ok || keyid < 0 || keyid > 255 {
+		return nil, 0, fmt.Errorf("aesgcm.keyid %v not in aesgcm.keys", keyid)
+	}
+	return keys, byte(keyid), nil
+}
+
+// aesgcmSubkey derive a subkey, of same length as the pre-shared key,
+// for packets encrypted by the side that picked txsalt and decrypted by
+// the side that picked rxsalt.
+func aesgcmSubkey(key, txsalt, rxsalt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("gofast.aesgcm"))
+	for _, salt := range [][]byte{txsalt, rxsalt} {
+		mac.Write([]byte{byte(len(salt))})
+		mac.Write(salt)
+	}
+	block, err := aes.NewCipher(mac.Sum(nil)[:len(key)])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aesgcmSalts return local salt and remote's salt, remote's salt is nil
+// till tags are negotiated.
+func aesgcmSalts(t *Transport) (localsalt, peersalt []byte) {
+	if t == nil {
+		return nil, nil
+	}
+	peersalt, _ = t.peersalt.Load().([]byte)
+	return t.salt, peersalt
+}
+
+func aesgcmerror(t *Transport, op, format string, args ...interface{}) {
+	if t != nil {
+		warnf("%v aesgcm %v failed, "+format+"\n",
+			append([]interface{}{t.logprefix, op}, args...)...)
+	}
+}
+
+func init() {
+	opts := TagOptions{Stateful: true, Integrity: true}
+	registertag("aesgcm", tagAESGCM, makeAESGCM, opts)
+}
@@ -0,0 +1,228 @@
+package gofast
+
+import "testing"
+import "bytes"
+import "reflect"
+import "sync"
+import "io/ioutil"
+
+import s "github.com/bnclabs/gosettings"
+
+var testAESKey1 = "1:000102030405060708090a0b0c0d0e0f"
+var testAESKey2 = "2:101112131415161718191a1b1c1d1e1f"
+
+func TestTagAESGCM(t *testing.T) {
+	settings := s.Settings{"aesgcm.keys": testAESKey1, "aesgcm.keyid": 1}
+	enc, dec := makeAESGCM(nil, settings)
+	if tag := tagFactory["aesgcm"].id; tag != tagAESGCM {
+		t.Errorf("expected %v, got %v", tagAESGCM, tag)
+	}
+	// test with valid input
+	ref := "hello world"
+	in, out := make([]byte, 1024), make([]byte, 1024)
+	n := enc([]byte(ref), in)
+	if n != len(ref)+aesgcmOverhead {
+		t.Errorf("expected %v, got %v", len(ref)+aesgcmOverhead, n)
+	} else if bytes.Contains(in[:n], []byte(ref)) {
+		t.Errorf("unexpected plain text %v", in[:n])
+	}
+	m := dec(in[:n], out)
+	if s := string(out[:m]); s != ref {
+		t.Errorf("expected %v, got %v", ref, s)
+	}
+	// test with replayed input
+	if m = dec(in[:n], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// nonce shall not repeat.
+	in2 := make([]byte, 1024)
+	n2 := enc([]byte(ref), in2)
+	if bytes.Equal(in[:n], in2[:n2]) {
+		t.Errorf("expected different nonce, got %v", in2[:n2])
+	}
+	// test with tampered input
+	in3 := append([]byte{}, in2[:n2]...)
+	in3[n2-1] ^= 0x01
+	if m = dec(in3, out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	if m = dec(in[:aesgcmOverhead-1], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// test with unknown keyid
+	in3 = append([]byte{}, in2[:n2]...)
+	in3[0] = 2
+	if m = dec(in3, out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// tampered packets shall not advance the counter.
+	if m = dec(in2[:n2], out); string(out[:m]) != ref {
+		t.Errorf("expected %v, got %v", ref, string(out[:m]))
+	}
+	// integrity tag shall not skip empty input.
+	if n = enc([]byte{}, in); n != aesgcmOverhead {
+		t.Errorf("expected %v, got %v", aesgcmOverhead, n)
+	}
+	// test with insufficient buffer for encoder
+	if n = enc([]byte(ref), in[:len(ref)+aesgcmOverhead-1]); n != -1 {
+		t.Errorf("expected %v, got %v", -1, n)
+	}
+}
+
+func TestAESGCMSubkey(t *testing.T) {
+	keys, _, err := aesgcmKeys(
+		s.Settings{"aesgcm.keys": testAESKey1, "aesgcm.keyid": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	salta, saltb := []byte("salt-a"), []byte("salt-b")
+	aeadab, _ := aesgcmSubkey(keys[1], salta, saltb)
+	aeadba, _ := aesgcmSubkey(keys[1], saltb, salta)
+	aeadrx, _ := aesgcmSubkey(keys[1], salta, saltb)
+
+	nonce, ref := make([]byte, aesgcmNonce), []byte("hello world")
+	sealed := aeadab.Seal(nil, nonce, ref, nil)
+	if plain, err := aeadrx.Open(nil, nonce, sealed, nil); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(plain, ref) {
+		t.Errorf("expected %s, got %s", ref, plain)
+	}
+	// subkeys are unique for every direction.
+	if _, err := aeadba.Open(nil, nonce, sealed, nil); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestAESGCMRotate(t *testing.T) {
+	keys := testAESKey1 + "," + testAESKey2
+	settings := func(keyid int) s.Settings {
+		return s.Settings{"aesgcm.keys": keys, "aesgcm.keyid": keyid}
+	}
+	enc1, _ := makeAESGCM(nil, settings(1))
+	enc2, _ := makeAESGCM(nil, settings(2))
+
+	ref := "hello world"
+	in, out := make([]byte, 1024), make([]byte, 1024)
+	for _, enc := range []TagFn{enc1, enc2} {
+		_, dec := makeAESGCM(nil, settings(1))
+		n := enc([]byte(ref), in)
+		if m := dec(in[:n], out); string(out[:m]) != ref {
+			t.Errorf("expected %v, got %v", ref, string(out[:m]))
+		}
+	}
+}
+
+func TestAESGCMKeys(t *testing.T) {
+	testcases := []s.Settings{
+		{"aesgcm.keys": "", "aesgcm.keyid": 0},
+		{"aesgcm.keys": testAESKey1, "aesgcm.keyid": 2},
+		{"aesgcm.keys": "1:0001", "aesgcm.keyid": 1},
+		{"aesgcm.keys": "1:xyz", "aesgcm.keyid": 1},
+		{"aesgcm.keys": "256:000102030405060708090a0b0c0d0e0f", "aesgcm.keyid": 0},
+		{"aesgcm.keys": "000102030405060708090a0b0c0d0e0f", "aesgcm.keyid": 0},
+	}
+	for _, setts := range testcases {
+		if _, _, err := aesgcmKeys(setts); err == nil {
+			t.Errorf("expected error for %v", setts)
+		}
+	}
+	// decoder shall not panic when keys are not configured.
+	_, dec := makeAESGCM(nil, testcases[0])
+	if m := dec(make([]byte, 64), make([]byte, 64)); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+}
+
+func TestAESGCMTransport(t *testing.T) {
+	keys := testAESKey1 + "," + testAESKey2
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["tags"], setts["aesgcm.keys"], setts["aesgcm.keyid"] = "aesgcm", keys, 1
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["tags"], setts["aesgcm.keys"], setts["aesgcm.keyid"] = "aesgcm", keys, 2
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+	// concurrent requests shall be encrypted and transmitted in order.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				msg, resp := &testMessage{uint64(i*100 + j)}, &testMessage{}
+				if err := transc.Request(msg, true, resp); err != nil {
+					t.Error(err)
+				} else if !reflect.DeepEqual(resp, msg) {
+					t.Errorf("expected %v, got %v", msg, resp)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 0) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_rxreq", 102) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if cCounts := transc.Stat(); !verify(cCounts, "n_corrupt", 0) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func BenchmarkAESGCMEnc1K(b *testing.B) {
+	settings := s.Settings{"aesgcm.keys": testAESKey1, "aesgcm.keyid": 1}
+	enc, _ := makeAESGCM(nil, settings)
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, 1024*1024)
+	b.ResetTimer()
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = enc(s, out)
+	}
+	b.SetBytes(int64(n))
+}
+
+func BenchmarkAESGCMDec1K(b *testing.B) {
+	settings := s.Settings{"aesgcm.keys": testAESKey1, "aesgcm.keyid": 1}
+	enc, dec := makeAESGCM(nil, settings)
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
+	b.ResetTimer()
+	var m int
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		n := enc(s, in) // decoder rejects replayed packets.
+		b.StartTimer()
+		m = dec(in[:n], out)
+	}
+	b.SetBytes(int64(m))
+}
@@ -0,0 +1,34 @@
+package gofast
+
+import "hash/crc32"
+import "encoding/binary"
+
+import s "github.com/bnclabs/gosettings"
+
+var crc32ctable = crc32.MakeTable(crc32.Castagnoli)
+
+func makeCRC32C(t *Transport, _ s.Settings) (TagFn, TagFn) {
+	enc := func(in, out []byte) int {
+		if len(in) == 0 || (len(in)+4) > len(out) {
+			return 0
+		}
+		n := copy(out, in)
+		binary.BigEndian.PutUint32(out[n:], crc32.Checksum(in, crc32ctable))
+		return n + 4
+	}
+	dec := func(in, out []byte) int {
+		if len(in) < 4 {
+			return -1
+		}
+		n := len(in) - 4
+		if crc32.Checksum(in[:n], crc32ctable) != binary.BigEndian.Uint32(in[n:]) {
+			return -1
+		}
+		return copy(out, in[:n])
+	}
+	return enc, dec
+}
+
+func init() {
+	registertag("crc32c", tagCrc32c, makeCRC32C, TagOptions{})
+}
@@ -0,0 +1,105 @@
+package gofast
+
+import "testing"
+import "time"
+import "io/ioutil"
+
+import s "github.com/bnclabs/gosettings"
+
+func TestTagCRC32C(t *testing.T) {
+	enc, dec := makeCRC32C(nil, s.Settings{})
+	if tag := tagFactory["crc32c"].id; tag != tagCrc32c {
+		t.Errorf("expected %v, got %v", tagCrc32c, tag)
+	}
+	// test with valid input
+	ref := "hello world"
+	in, out := make([]byte, 1024), make([]byte, 1024)
+	n := enc([]byte(ref), in)
+	if n != len(ref)+4 {
+		t.Errorf("expected %v, got %v", len(ref)+4, n)
+	}
+	m := dec(in[:n], out)
+	if s := string(out[:m]); s != ref {
+		t.Errorf("expected %v, got %v", ref, s)
+	}
+	// test with corrupted input
+	in[1] ^= 0x10
+	if m = dec(in[:n], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	if m = dec(in[:3], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// test with empty input for encoder
+	if n = enc([]byte{}, in); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	// test with insufficient output buffer
+	if n = enc([]byte(ref), in[:len(ref)]); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+}
+
+func TestCorruptClose(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["tags"], setts["corrupt.close"] = "crc32c", true
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	transc := newClient("client", addr, "crc32c")
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		t.Fatal(err)
+	}
+	transv := <-serverch
+
+	// corrupt the checksum for all outgoing packets.
+	enc, _ := makeCRC32C(transc, transc.settings)
+	transc.tagencoders()[0].fn = func(in, out []byte) int {
+		n := enc(in, out)
+		out[n-1] ^= 0xff
+		return n
+	}
+	if err := transc.Post(&testMessage{1234}, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !transv.IsClosed() {
+		t.Errorf("expected server to be closed")
+	}
+
+	lis.Close()
+	transc.Close()
+}
+
+func BenchmarkCRC32CEnc1K(b *testing.B) {
+	enc, _ := makeCRC32C(nil, s.Settings{})
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, 1024*1024)
+	b.ResetTimer()
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = enc(s, out)
+	}
+	b.SetBytes(int64(n))
+}
+
+func BenchmarkCRC32CDec1K(b *testing.B) {
+	enc, dec := makeCRC32C(nil, s.Settings{})
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
+	n := enc(s, in)
+	b.ResetTimer()
+	var m int
+	for i := 0; i < b.N; i++ {
+		m = dec(in[:n], out)
+	}
+	b.SetBytes(int64(m))
+}
@@ -2,46 +2,90 @@ package gofast
 
 import "compress/gzip"
 import "bytes"
+import "errors"
 import "fmt"
+import "sync"
 
 import s "github.com/bnclabs/gosettings"
 
-func makeGzip(t *Transport, settings s.Settings) (uint64, tagfn, tagfn) {
+type gzipenc struct {
+	sink   bufsink
+	writer *gzip.Writer
+}
+
+func makeGzip(t *Transport, settings s.Settings) (TagFn, TagFn) {
+	level := int(settings.Uint64("gzip.level"))
+	// encoder can be called concurrently, pool the writers.
+	encoders := &sync.Pool{New: func() interface{} {
+		ge := &gzipenc{}
+		writer, err := gzip.NewWriterLevel(&ge.sink, level)
+		if err != nil {
+			panic(fmt.Errorf("error encoding gzip: %v", err))
+		}
+		ge.writer = writer
+		return ge
+	}}
 	enc := func(in, out []byte) int {
-		level := int(settings.Uint64("gzip.level"))
 		if len(in) == 0 { // empty input
 			return 0
 		}
-		wbuf := bytes.NewBuffer(out[:0])
-		writer, err := gzip.NewWriterLevel(wbuf, level)
-		if err != nil {
-			panic(fmt.Errorf("error encoding gzip: %v", err))
-		}
-		_, err = writer.Write(in)
-		if err != nil {
+		ge := encoders.Get().(*gzipenc)
+		defer encoders.Put(ge)
+
+		ge.sink.reset(out)
+		ge.writer.Reset(&ge.sink)
+		if _, err := ge.writer.Write(in); err == errSinkFull {
+			return 0 // skip tag, compressed payload won't fit in out.
+		} else if err != nil {
 			panic(fmt.Errorf("error encoding gzip: %v", err))
-		} else if err = writer.Flush(); err != nil {
+		} else if err = ge.writer.Flush(); err == errSinkFull {
+			return 0
+		} else if err != nil {
 			panic(fmt.Errorf("error encoding gzip: %v", err))
 		}
-		return wbuf.Len()
+		return ge.sink.n
 	}
+	// decoder is called only from doRx() routine, reuse the reader.
+	var rbuf bytes.Reader
+	var reader gzip.Reader
 	dec := func(in, out []byte) int {
 		if len(in) == 0 {
 			return 0
 		}
-		reader, err := gzip.NewReader(bytes.NewBuffer(in))
-		if err != nil {
-			panic(fmt.Errorf("error decoding gzip: %v", err))
+		rbuf.Reset(in)
+		if err := reader.Reset(&rbuf); err != nil {
+			return -1
 		}
-		n, err := reader.Read(out)
+		n, err := readAll(&reader, out)
 		if err != nil {
-			panic(fmt.Errorf("error decoding gzip: %v", err))
+			return -1
 		}
 		return n
 	}
-	return tagGzip, enc, dec
+	return enc, dec
+}
+
+// bufsink is io.Writer into a fixed size buffer, to avoid copying the
+// encoded output.
+type bufsink struct {
+	out []byte
+	n   int
+}
+
+var errSinkFull = errors.New("sink full")
+
+func (sink *bufsink) reset(out []byte) {
+	sink.out, sink.n = out, 0
+}
+
+func (sink *bufsink) Write(p []byte) (int, error) {
+	if sink.n+len(p) > len(sink.out) {
+		return 0, errSinkFull
+	}
+	sink.n += copy(sink.out[sink.n:], p)
+	return len(p), nil
 }
 
 func init() {
-	tagFactory["gzip"] = makeGzip
+	registertag("gzip", tagGzip, makeGzip, TagOptions{})
 }
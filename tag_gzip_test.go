@@ -11,8 +11,8 @@ func TestTagGzip(t *testing.T) {
 		"buffersize": 1024 * 1024,
 		"gzip.level": flate.DefaultCompression,
 	}
-	tag, enc, dec := makeGzip(nil, settings)
-	if tag != tagGzip {
+	enc, dec := makeGzip(nil, settings)
+	if tag := tagFactory["gzip"].id; tag != tagGzip {
 		t.Errorf("expected %v, got %v", tagGzip, tag)
 	}
 	// test with valid input
@@ -31,6 +31,23 @@ func TestTagGzip(t *testing.T) {
 	if n = dec([]byte{}, in); n != 0 {
 		t.Errorf("expected %v, got %v", 0, n)
 	}
+	// test reuse of encoder and decoder, and insufficient buffers.
+	for _, ref := range []string{"hello", "world", ref + ref} {
+		n = enc([]byte(ref), in)
+		if m = dec(in[:n], out); string(out[:m]) != ref {
+			t.Errorf("expected %v, got %v", ref, string(out[:m]))
+		}
+		if m = dec(in[:n], out[:len(ref)-1]); m != -1 {
+			t.Errorf("expected %v, got %v", -1, m)
+		}
+	}
+	if n = enc([]byte(ref), in[:2]); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	// test with corrupt input for decoder
+	if m = dec([]byte("corrupt payload"), out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
 }
 
 func BenchmarkGzEnc1KFast(b *testing.B) {
@@ -38,7 +55,7 @@ func BenchmarkGzEnc1KFast(b *testing.B) {
 		"buffersize": 1024 * 1024,
 		"gzip.level": flate.BestSpeed,
 	}
-	_, enc, _ := makeGzip(nil, settings)
+	enc, _ := makeGzip(nil, settings)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
@@ -62,7 +79,7 @@ func BenchmarkGzDec1KFast(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
-	_, enc, dec := makeGzip(nil, settings)
+	enc, dec := makeGzip(nil, settings)
 	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
 	n := enc(s, in)
 	b.ResetTimer()
@@ -79,7 +96,7 @@ func BenchmarkGzEnc10KSmall(b *testing.B) {
 		"buffersize": 1024 * 1024,
 		"gzip.level": flate.BestSpeed,
 	}
-	_, enc, _ := makeGzip(nil, settings)
+	enc, _ := makeGzip(nil, settings)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
@@ -103,7 +120,7 @@ func BenchmarkGzDec10KSmall(b *testing.B) {
 		"buffersize": 1024 * 1024,
 		"gzip.level": flate.BestSpeed,
 	}
-	_, enc, dec := makeGzip(nil, settings)
+	enc, dec := makeGzip(nil, settings)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
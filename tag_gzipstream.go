@@ -0,0 +1,79 @@
+package gofast
+
+import "compress/flate"
+import "encoding/binary"
+import "bytes"
+import "io"
+import "fmt"
+import "sync"
+
+import s "github.com/bnclabs/gosettings"
+
+// worst case expansion of deflate is 5 bytes for every stored block,
+// plus the sync-flush marker and the length prefix.
+func gzipstreamOverhead(n int) int {
+	return binary.MaxVarintLen64 + 16 + (5 * ((n / 16384) + 1))
+}
+
+// makeGzipStream return a stateful pair of deflate encoder and decoder,
+// dictionary is carried over from one packet to the next, and every
+// packet is flushed on a byte boundary so that it can be decoded
+// without waiting for subsequent packets.
+//
+//	| uvarint(len(in)) | deflate(in) + sync-flush |
+func makeGzipStream(t *Transport, settings s.Settings) (TagFn, TagFn) {
+	level := int(settings.Uint64("gzip.level"))
+
+	var mu sync.Mutex
+	var sink bufsink
+	writer, err := flate.NewWriter(&sink, level)
+	if err != nil {
+		panic(fmt.Errorf("error encoding gzipstream: %v", err))
+	}
+	enc := func(in, out []byte) int {
+		if len(in) == 0 {
+			return 0
+		} else if len(in)+gzipstreamOverhead(len(in)) > len(out) {
+			return 0 // skip tag, before touching the dictionary.
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		n := binary.PutUvarint(out, uint64(len(in)))
+		sink.reset(out[n:])
+		if _, err := writer.Write(in); err != nil {
+			panic(fmt.Errorf("error encoding gzipstream: %v", err))
+		} else if err = writer.Flush(); err != nil {
+			panic(fmt.Errorf("error encoding gzipstream: %v", err))
+		}
+		return n + sink.n
+	}
+
+	// decoder is called only from doRx() routine.
+	var src bytes.Buffer
+	reader, broken := flate.NewReader(&src), false
+	dec := func(in, out []byte) int {
+		if len(in) == 0 {
+			return 0
+		} else if broken { // dictionary is out of sync with remote.
+			return -1
+		}
+		ln, n := binary.Uvarint(in)
+		if n <= 0 || ln > uint64(len(out)) {
+			broken = true
+			return -1
+		}
+		src.Write(in[n:])
+		if _, err := io.ReadFull(reader, out[:ln]); err != nil {
+			broken = true
+			return -1
+		}
+		return int(ln)
+	}
+	return enc, dec
+}
+
+func init() {
+	opts := TagOptions{Stateful: true}
+	registertag("gzipstream", tagGzipStream, makeGzipStream, opts)
+}
@@ -0,0 +1,174 @@
+package gofast
+
+import "testing"
+import "compress/flate"
+import "io/ioutil"
+import "reflect"
+import "sync"
+import "fmt"
+
+import s "github.com/bnclabs/gosettings"
+
+func TestTagGzipStream(t *testing.T) {
+	settings := s.Settings{"gzip.level": flate.BestSpeed}
+	enc, dec := makeGzipStream(nil, settings)
+	if tag := tagFactory["gzipstream"].id; tag != tagGzipStream {
+		t.Errorf("expected %v, got %v", tagGzipStream, tag)
+	} else if !tagFactory["gzipstream"].stateful {
+		t.Errorf("expected gzipstream to be stateful")
+	}
+	// test with valid input, dictionary carried across packets.
+	data, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := string(data)
+	in, out := make([]byte, 4096), make([]byte, 4096)
+	first := 0
+	for i := 0; i < 10; i++ {
+		n := enc([]byte(ref), in)
+		if i == 0 {
+			first = n
+		} else if n >= first {
+			t.Errorf("expected < %v, got %v", first, n)
+		}
+		m := dec(in[:n], out)
+		if s := string(out[:m]); s != ref {
+			t.Errorf("expected %v, got %v", ref, s)
+		}
+	}
+	// test with empty input
+	if n := enc([]byte{}, in); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	} else if n = dec([]byte{}, out); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	// test skip on insufficient buffer
+	if n := enc([]byte(ref), in[:len(ref)]); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	// skipped packets shall not disturb the dictionary.
+	n := enc([]byte(ref), in)
+	if m := dec(in[:n], out); string(out[:m]) != ref {
+		t.Errorf("expected %v, got %v", ref, string(out[:m]))
+	}
+}
+
+func TestGzipStreamCorrupt(t *testing.T) {
+	settings := s.Settings{"gzip.level": flate.BestSpeed}
+	enc, dec := makeGzipStream(nil, settings)
+	ref := "hello world"
+	in, out := make([]byte, 1024), make([]byte, 1024)
+	n := enc([]byte(ref), in)
+	if m := dec(in[:n], out[:len(ref)-1]); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// once out of sync, decoder shall fail all subsequent packets.
+	n = enc([]byte(ref), in)
+	if m := dec(in[:n], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+
+	// missing packet.
+	data, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out = make([]byte, 4096), make([]byte, 4096)
+	enc, dec = makeGzipStream(nil, settings)
+	enc(data, in)
+	n = enc(data, in)
+	if m := dec(in[:n], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+}
+
+func TestGzipStreamTransport(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+100)
+	setts["tags"] = "gzipstream,crc32c"
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = newsetts(TagOpaqueStart+101, TagOpaqueStart+200)
+	setts["tags"] = "gzipstream,crc32c"
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	if transc.txordered != 1 || transv.txordered != 1 {
+		t.Errorf("expected ordered tx for stateful tags")
+	}
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, false)
+			return nil
+		})
+
+	var wg sync.WaitGroup
+	errch := make(chan error, 1000)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				msg, resp := &testMessage{uint64(i*1000 + j)}, &testMessage{}
+				if err := transc.Request(msg, true, resp); err != nil {
+					errch <- err
+				} else if !reflect.DeepEqual(resp, msg) {
+					errch <- fmt.Errorf("expected %v, got %v", msg, resp)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errch)
+	for err := range errch {
+		t.Error(err)
+	}
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 0) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if cCounts := transc.Stat(); !verify(cCounts, "n_corrupt", 0) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func BenchmarkGzStreamEnc1K(b *testing.B) {
+	settings := s.Settings{"gzip.level": flate.BestSpeed}
+	enc, _ := makeGzipStream(nil, settings)
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, 1024*1024)
+	b.ResetTimer()
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = enc(s, out)
+	}
+	b.SetBytes(int64(n))
+}
+
+func BenchmarkGzStreamDec1K(b *testing.B) {
+	settings := s.Settings{"gzip.level": flate.BestSpeed}
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	enc, dec := makeGzipStream(nil, settings)
+	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
+	b.ResetTimer()
+	var m int
+	for i := 0; i < b.N; i++ {
+		n := enc(s, in)
+		m = dec(in[:n], out)
+	}
+	b.SetBytes(int64(m))
+}
@@ -0,0 +1,53 @@
+package gofast
+
+import "crypto/hmac"
+import "crypto/sha256"
+
+import s "github.com/bnclabs/gosettings"
+
+func makeHMAC(t *Transport, settings s.Settings) (TagFn, TagFn) {
+	key := []byte(settings.String("hmac.key"))
+	size := sha256.Size
+	enc := func(in, out []byte) int {
+		if (len(in) + size) > len(out) {
+			return -1
+		}
+		// encoder can be called concurrently from several streams.
+		mac := hmac.New(sha256.New, key)
+		n := copy(out, in)
+		mac.Write(in)
+		return len(mac.Sum(out[:n]))
+	}
+	// decoder is called only from doRx() routine.
+	dmac := hmac.New(sha256.New, key)
+	dec := func(in, out []byte) int {
+		if len(in) < size {
+			return -1
+		}
+		n := len(in) - size
+		dmac.Reset()
+		dmac.Write(in[:n])
+		if !hmac.Equal(dmac.Sum(nil), in[n:]) {
+			return -1
+		}
+		return copy(out, in[:n])
+	}
+	return enc, dec
+}
+
+// whoamimac shall return hmac over request and response whoami, so that
+// either side can detect tampering of whoami, like stripping of tags,
+// during handshake. Return nil if "hmac" is not configured.
+func whoamimac(t *Transport, req, resp *whoamiMsg) []byte {
+	if !hasString("hmac", t.getTags(t.settings.String("tags"), []string{})) {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(t.settings.String("hmac.key")))
+	mac.Write(req.Encode(nil))
+	mac.Write(resp.Encode(nil))
+	return mac.Sum(nil)
+}
+
+func init() {
+	registertag("hmac", tagHMAC, makeHMAC, TagOptions{Integrity: true})
+}
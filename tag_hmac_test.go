@@ -0,0 +1,125 @@
+package gofast
+
+import "testing"
+import "time"
+import "reflect"
+import "io/ioutil"
+import "crypto/sha256"
+
+import s "github.com/bnclabs/gosettings"
+
+func TestTagHMAC(t *testing.T) {
+	enc, dec := makeHMAC(nil, s.Settings{"hmac.key": "secret"})
+	if tag := tagFactory["hmac"].id; tag != tagHMAC {
+		t.Errorf("expected %v, got %v", tagHMAC, tag)
+	}
+	// test with valid input
+	ref := "hello world"
+	in, out := make([]byte, 1024), make([]byte, 1024)
+	n := enc([]byte(ref), in)
+	m := dec(in[:n], out)
+	if s := string(out[:m]); s != ref {
+		t.Errorf("expected %v, got %v", ref, s)
+	}
+	// test with different key
+	_, dec = makeHMAC(nil, s.Settings{"hmac.key": "guess"})
+	if m = dec(in[:n], out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
+	// integrity tag shall not skip empty input.
+	if n = enc([]byte{}, in); n != sha256.Size {
+		t.Errorf("expected %v, got %v", sha256.Size, n)
+	}
+	// test with insufficient buffer for encoder
+	if n = enc([]byte(ref), in[:len(ref)+sha256.Size-1]); n != -1 {
+		t.Errorf("expected %v, got %v", -1, n)
+	}
+}
+
+func TestHMACMismatch(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["tags"], setts["hmac.key"] = "hmac", "secret"
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["tags"], setts["hmac.key"] = "hmac", "secret"
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	// sign outgoing packets with a different key.
+	enc, _ := makeHMAC(transc, s.Settings{"hmac.key": "guess"})
+	transc.tagencoders()[0].fn = enc
+	if err := transc.Post(msg, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if transv.IsClosed() {
+		t.Errorf("unexpected close")
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestHMACNokey(t *testing.T) {
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["tags"] = "hmac"
+	ver := testVersion(1)
+	conn := newTestConnection("laddr", "raddr", nil, true)
+	if _, err := NewTransport("nokey", conn, &ver, setts); err != ErrorInvalidTag {
+		t.Errorf("expected %v, got %v", ErrorInvalidTag, err)
+	}
+}
+
+func BenchmarkHMACEnc1K(b *testing.B) {
+	enc, _ := makeHMAC(nil, s.Settings{"hmac.key": "secret"})
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, 1024*1024)
+	b.ResetTimer()
+	var n int
+	for i := 0; i < b.N; i++ {
+		n = enc(s, out)
+	}
+	b.SetBytes(int64(n))
+}
+
+func BenchmarkHMACDec1K(b *testing.B) {
+	enc, dec := makeHMAC(nil, s.Settings{"hmac.key": "secret"})
+	s, err := ioutil.ReadFile("testdata/1k.json")
+	if err != nil {
+		panic(err)
+	}
+	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
+	n := enc(s, in)
+	b.ResetTimer()
+	var m int
+	for i := 0; i < b.N; i++ {
+		m = dec(in[:n], out)
+	}
+	b.SetBytes(int64(m))
+}
@@ -4,53 +4,85 @@ import "compress/lzw"
 import "bytes"
 import "io"
 import "fmt"
+import "sync"
 
 import s "github.com/bnclabs/gosettings"
 
-func makeLZW(t *Transport, _ s.Settings) (uint64, tagfn, tagfn) {
-	var wbuf bytes.Buffer
+type lzwenc struct {
+	sink   bufsink
+	writer *lzw.Writer
+}
+
+func makeLZW(t *Transport, _ s.Settings) (TagFn, TagFn) {
+	// encoder can be called concurrently, pool the writers.
+	encoders := &sync.Pool{New: func() interface{} {
+		le := &lzwenc{}
+		le.writer = lzw.NewWriter(&le.sink, lzw.LSB, 8 /*litWidth*/).(*lzw.Writer)
+		return le
+	}}
 	enc := func(in, out []byte) int {
 		if len(in) == 0 {
 			return 0
 		}
-		wbuf.Reset()
-		writer := lzw.NewWriter(&wbuf, lzw.LSB, 8 /*litWidth*/)
-		if _, err := writer.Write(in); err != nil {
+		le := encoders.Get().(*lzwenc)
+		defer encoders.Put(le)
+
+		le.sink.reset(out)
+		le.writer.Reset(&le.sink, lzw.LSB, 8 /*litWidth*/)
+		if _, err := le.writer.Write(in); err == errSinkFull {
+			return 0 // skip tag, compressed payload won't fit in out.
+		} else if err != nil {
+			panic(fmt.Errorf("error encoding lzw: %v", err))
+		} else if err = le.writer.Close(); err == errSinkFull {
+			return 0
+		} else if err != nil {
 			panic(fmt.Errorf("error encoding lzw: %v", err))
 		}
-		writer.Close()
-		return copy(out, wbuf.Bytes())
+		return le.sink.n
 	}
+	// decoder is called only from doRx() routine, reuse the reader.
+	var rbuf bytes.Reader
+	reader := lzw.NewReader(&rbuf, lzw.LSB, 8 /*litWidth*/).(*lzw.Reader)
 	dec := func(in, out []byte) int {
 		if len(in) == 0 {
 			return 0
 		}
-		reader := lzw.NewReader(bytes.NewReader(in), lzw.LSB, 8 /*litWidth*/)
+		rbuf.Reset(in)
+		reader.Reset(&rbuf, lzw.LSB, 8 /*litWidth*/)
 		n, err := readAll(reader, out)
 		if err != nil {
-			panic(fmt.Errorf("error decoding lzw: %v", err))
+			return -1
 		}
-		reader.Close()
 		return n
 	}
-	return tagLzw, enc, dec
+	return enc, dec
 }
 
+// readAll from r into out till end of input, return error if out is not
+// big enough. Compressed payloads are flushed but not closed, hence
+// io.ErrUnexpectedEOF is also treated as end of input.
 func readAll(r io.Reader, out []byte) (n int, err error) {
-	c := 0
+	var c int
+	var scratch [1]byte
 	for err == nil {
+		if n == len(out) { // make sure there is nothing more to read.
+			if c, err = r.Read(scratch[:]); c > 0 {
+				return n, fmt.Errorf("insufficient buffer %v", len(out))
+			}
+			break
+		}
 		// Per http://golang.org/pkg/io/#Reader, it is valid for Read to
 		// return EOF with non-zero number of bytes at the end of the
 		// input stream
 		c, err = r.Read(out[n:])
 		n += c
 	}
-	if err == io.EOF {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return n, nil
 	}
 	return n, err
 }
 
 func init() {
-	tagFactory["lzw"] = makeLZW
+	registertag("lzw", tagLzw, makeLZW, TagOptions{})
 }
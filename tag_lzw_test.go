@@ -4,8 +4,8 @@ import "testing"
 import "io/ioutil"
 
 func TestTagLzw(t *testing.T) {
-	tag, enc, dec := makeLZW(nil, nil)
-	if tag != tagLzw {
+	enc, dec := makeLZW(nil, nil)
+	if tag := tagFactory["lzw"].id; tag != tagLzw {
 		t.Errorf("expected %v, got %v", tagLzw, tag)
 	}
 	// test with valid input
@@ -24,10 +24,27 @@ func TestTagLzw(t *testing.T) {
 	if n = dec([]byte{}, in); n != 0 {
 		t.Errorf("expected %v, got %v", 0, n)
 	}
+	// test reuse of encoder and decoder, and insufficient buffers.
+	for _, ref := range []string{"hello", "world", ref + ref} {
+		n = enc([]byte(ref), in)
+		if m = dec(in[:n], out); string(out[:m]) != ref {
+			t.Errorf("expected %v, got %v", ref, string(out[:m]))
+		}
+		if m = dec(in[:n], out[:len(ref)-1]); m != -1 {
+			t.Errorf("expected %v, got %v", -1, m)
+		}
+	}
+	if n = enc([]byte(ref), in[:2]); n != 0 {
+		t.Errorf("expected %v, got %v", 0, n)
+	}
+	// test with corrupt input for decoder
+	if m = dec([]byte("corrupt payload"), out); m != -1 {
+		t.Errorf("expected %v, got %v", -1, m)
+	}
 }
 
 func BenchmarkLzwEnc1KFast(b *testing.B) {
-	_, enc, _ := makeLZW(nil, nil)
+	enc, _ := makeLZW(nil, nil)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
@@ -47,7 +64,7 @@ func BenchmarkLzwDec1KFast(b *testing.B) {
 	if err != nil {
 		panic(err)
 	}
-	_, enc, dec := makeLZW(nil, nil)
+	enc, dec := makeLZW(nil, nil)
 	in, out := make([]byte, 1024*1024), make([]byte, 1024*1024)
 	n := enc(s, in)
 	b.ResetTimer()
@@ -60,7 +77,7 @@ func BenchmarkLzwDec1KFast(b *testing.B) {
 }
 
 func BenchmarkLzwEnc10KSmall(b *testing.B) {
-	_, enc, _ := makeLZW(nil, nil)
+	enc, _ := makeLZW(nil, nil)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
@@ -80,7 +97,7 @@ func BenchmarkLzwEnc10KSmall(b *testing.B) {
 }
 
 func BenchmarkLzwDec10KSmall(b *testing.B) {
-	_, enc, dec := makeLZW(nil, nil)
+	enc, dec := makeLZW(nil, nil)
 	s, err := ioutil.ReadFile("testdata/1k.json")
 	if err != nil {
 		panic(err)
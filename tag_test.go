@@ -0,0 +1,368 @@
+package gofast
+
+import "testing"
+import "reflect"
+import "strings"
+import "time"
+import "net"
+import "fmt"
+
+import s "github.com/bnclabs/gosettings"
+
+func TestTagPipeline(t *testing.T) {
+	testcases := [][2]string{ // server tags, client tags
+		{"lzw,gzip,crc32c", "lzw,gzip,crc32c"},
+		{"crc32c,gzip,lzw", "crc32c,gzip,lzw"},
+		{"gzip,hmac", "gzip,hmac"},
+		{"lzw,aesgcm,crc32c", "lzw,aesgcm,crc32c"},
+		{"crc32c,hmac,aesgcm,gzip,lzw", "crc32c,hmac,aesgcm,gzip,lzw"},
+		{"lzw,gzip", "gzip,lzw"},
+		{"gzip,crc32c", ""},
+		{"gzipstream,aesgcm", "gzipstream,aesgcm"},
+		{"gzip,crc32c,lzw", "lzw,hmac,crc32c"},
+	}
+	for _, tc := range testcases {
+		addr := <-testBindAddrs
+		lis, serverch := newServersetts("server", addr, newtagsetts(tc[0], true))
+		transc := newClientsetts("client", addr, newtagsetts(tc[1], false))
+		transc.SubscribeMessage(&testMessage{}, nil)
+		if err := transc.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		transv := <-serverch
+		transv.SubscribeMessage(
+			&testMessage{},
+			func(s *Stream, rxmsg BinMessage) StreamCallback {
+				var m testMessage
+				m.Decode(rxmsg.Data)
+				s.Response(&m, true)
+				return nil
+			})
+
+		// client shall encode common tags in the order advertised by
+		// server, and vice versa.
+		ctags, stags := tagintersect(tc[0], tc[1]), tagintersect(tc[1], tc[0])
+		if tags := tagnames(transc.tagencoders()); tags != ctags {
+			t.Errorf("expected %q, got %q", ctags, tags)
+		} else if tags := tagnames(transv.tagencoders()); tags != stags {
+			t.Errorf("expected %q, got %q", stags, tags)
+		}
+		for i := uint64(0); i < 10; i++ {
+			msg, resp := &testMessage{i}, &testMessage{}
+			if err := transc.Request(msg, true, resp); err != nil {
+				t.Errorf("%v: %v", tc, err)
+			} else if !reflect.DeepEqual(resp, msg) {
+				t.Errorf("%v: expected %v, got %v", tc, msg, resp)
+			}
+		}
+		cCounts, sCounts := transc.Stat(), transv.Stat()
+		if !verify(cCounts, "n_corrupt", 0) || !verify(sCounts, "n_corrupt", 0) {
+			t.Errorf("%v: unexpected corrupt %v %v", tc, cCounts, sCounts)
+		}
+
+		lis.Close()
+		transc.Close()
+		transv.Close()
+	}
+}
+
+func TestTagOutOfOrder(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newtagsetts("lzw,crc32c", true)
+	lis, serverch := newServersetts("server", addr, setts)
+	transc := newClientsetts("client", addr, newtagsetts("crc32c,lzw", false))
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+
+	// apply tags in the reverse order.
+	codecs := transc.tagencoders()
+	codecs[0], codecs[1] = codecs[1], codecs[0]
+	if err := transc.Post(&testMessage{1234}, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTagNegotiate(t *testing.T) {
+	testcases := []struct {
+		stags, sreq string
+		ctags, creq string
+		err         error
+	}{
+		{"gzip,crc32c", "crc32c", "crc32c,lzw", "", nil},
+		{"gzip,crc32c", "crc32c", "lzw,crc32c", "crc32c", nil},
+		{"gzip,crc32c", "crc32c", "gzip,lzw", "", ErrTagMismatch},
+		{"gzip", "", "gzip,hmac", "hmac", ErrTagMismatch},
+		{"", "", "aesgcm", "aesgcm", ErrTagMismatch},
+	}
+	for i, tc := range testcases {
+		ver := testVersion(1)
+		sconn, cconn := net.Pipe()
+		setts := newtagsetts(tc.stags, true)
+		setts["tags.required"] = tc.sreq
+		name := fmt.Sprintf("negotiate-server-%v", i)
+		transv, err := NewTransport(name, sconn, &ver, setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setts = newtagsetts(tc.ctags, false)
+		setts["tags.required"] = tc.creq
+		name = fmt.Sprintf("negotiate-client-%v", i)
+		transc, err := NewTransport(name, cconn, &ver, setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errch := make(chan error, 1)
+		go func() { errch <- transv.Handshake() }()
+		if err := transc.Handshake(); err != tc.err {
+			t.Errorf("%v: expected %v, got %v", tc, tc.err, err)
+		} else if err := <-errch; err != tc.err {
+			t.Errorf("%v: expected %v, got %v", tc, tc.err, err)
+		}
+		if tc.err != nil && (!transc.IsClosed() || !transv.IsClosed()) {
+			t.Errorf("%v: expected transports to be closed", tc)
+		}
+		transc.Close()
+		transv.Close()
+	}
+}
+
+func TestTagRequired(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newtagsetts("gzip,hmac", true)
+	setts["tags.required"] = "hmac"
+	lis, serverch := newServersetts("server", addr, setts)
+	transc := newClientsetts("client", addr, newtagsetts("hmac,gzip", false))
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	if wai, err := transc.Whoami(); err != nil {
+		t.Fatal(err)
+	} else if ref := "hmac"; wai.RequiredTags() != ref {
+		t.Errorf("expected %v, got %v", ref, wai.RequiredTags())
+	}
+
+	// strip the required hmac tag, packet shall be dropped.
+	codecs := transc.tagencoders()
+	transc.tagenc.Store(codecs[:1])
+	if err := transc.Post(&testMessage{1234}, true); err != nil {
+		t.Fatal(err)
+	}
+	// integrity tags are never skipped.
+	codecs[1].fn = func(in, out []byte) int { return 0 }
+	transc.tagenc.Store(codecs)
+	if err := transc.Post(&testMessage{1234}, true); err != ErrTagEncode {
+		t.Errorf("expected %v, got %v", ErrTagEncode, err)
+	}
+	// skip the optional gzip tag.
+	codecs[0].fn = func(in, out []byte) int { return 0 }
+	codecs[1].fn, _ = makeHMAC(transc, transc.settings)
+	if err := transc.Post(&testMessage{1234}, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_rxpost", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTagIntegrity(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServersetts("server", addr, newtagsetts("hmac", true))
+	transc := newClientsetts("client", addr, newtagsetts("gzip,hmac", false))
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil
+		})
+
+	// negotiated hmac is required, though not configured as required.
+	codecs := transc.tagencoders()
+	if len(codecs) != 1 || !codecs[0].integrity {
+		t.Fatalf("unexpected codecs %v", codecs)
+	}
+	stream, err := transc.Stream(&testMessage{1}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transc.tagenc.Store([]tagcodec{})
+	if err := transc.Post(&testMessage{1234}, true); err != nil {
+		t.Fatal(err)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 2) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_rxpost", 0, "n_rxfin", 0) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	// end-packets carry the integrity tags.
+	transc.tagenc.Store(codecs)
+	stream, err = transc.Stream(&testMessage{2}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sCounts := transv.Stat(); !verify(sCounts, "n_corrupt", 2) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	} else if !verify(sCounts, "n_rxfin", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTagDuplicate(t *testing.T) {
+	ver := testVersion(1)
+	conn := newTestConnection("laddr", "raddr", nil, true)
+	setts := newtagsetts("gzip,lzw,gzip", false)
+	if _, err := NewTransport("dup", conn, &ver, setts); err != ErrorInvalidTag {
+		t.Errorf("expected %v, got %v", ErrorInvalidTag, err)
+	}
+	// required tags shall be configured.
+	setts = newtagsetts("gzip", false)
+	setts["tags.required"] = "lzw"
+	if _, err := NewTransport("req", conn, &ver, setts); err != ErrorInvalidTag {
+		t.Errorf("expected %v, got %v", ErrorInvalidTag, err)
+	}
+}
+
+func TestRegisterTag(t *testing.T) {
+	factory := func(t *Transport, _ s.Settings) (TagFn, TagFn) {
+		xor := func(in, out []byte) int {
+			for i, b := range in {
+				out[i] = b ^ 0x5a
+			}
+			return len(in)
+		}
+		return xor, xor
+	}
+	testcases := []struct {
+		name string
+		id   uint64
+		fn   TagFactory
+		err  error
+	}{
+		{"", TagCustomStart, factory, ErrorInvalidTag},
+		{"x,y", TagCustomStart, factory, ErrorInvalidTag},
+		{"xor", TagCustomStart, nil, ErrorInvalidTag},
+		{"xor", tagAESGCM, factory, ErrTagReserved},
+		{"xor", TagCustomStart - 1, factory, ErrTagReserved},
+		{"xor", TagOpaqueStart, factory, ErrTagReserved},
+		{"gzip", TagCustomStart, factory, ErrTagDuplicate},
+		{"xor", TagCustomStart, factory, nil},
+		{"xor2", TagCustomStart, factory, ErrTagDuplicate},
+		{"xor", TagCustomStart + 1, factory, ErrTagDuplicate},
+	}
+	defer delete(tagFactory, "xor")
+	for _, tc := range testcases {
+		err := RegisterTag(tc.name, tc.id, tc.fn, TagOptions{})
+		if err != tc.err {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+	// options shall mark the custom tag as stateful and integrity.
+	opts := TagOptions{Stateful: true, Integrity: true}
+	if err := RegisterTag("xorsum", TagCustomStart+1, factory, opts); err != nil {
+		t.Error(err)
+	} else if entry := tagFactory["xorsum"]; !entry.stateful || !entry.integrity {
+		t.Errorf("expected stateful and integrity tag, got %+v", entry)
+	} else if entry := tagFactory["xor"]; entry.stateful || entry.integrity {
+		t.Errorf("unexpected stateful or integrity tag, got %+v", entry)
+	}
+	delete(tagFactory, "xorsum")
+
+	// use custom tag along with builtin tags.
+	addr := <-testBindAddrs
+	setts := newtagsetts("gzip,xor,crc32c", true)
+	lis, serverch := newServersetts("server", addr, setts)
+	transc := newClientsetts("client", addr, newtagsetts("xor,crc32c", false))
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	transv := <-serverch
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+	if tags := tagnames(transc.tagencoders()); tags != "xor,crc32c" {
+		t.Errorf("expected %v, got %v", "xor,crc32c", tags)
+	}
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func newtagsetts(tags string, server bool) s.Settings {
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	if server {
+		setts = newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	}
+	setts["tags"], setts["hmac.key"] = tags, "secret"
+	setts["aesgcm.keys"], setts["aesgcm.keyid"] = testAESKey1, 1
+	return setts
+}
+
+func tagintersect(order, other string) string {
+	tags := []string{}
+	for _, tag := range csv2strings(order, []string{}) {
+		if hasString(tag, csv2strings(other, []string{})) {
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+func tagnames(codecs []tagcodec) string {
+	names := []string{}
+	for _, codec := range codecs {
+		for name, entry := range tagFactory {
+			if entry.id == codec.tag {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
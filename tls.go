@@ -0,0 +1,68 @@
+package gofast
+
+import "net"
+import "net/url"
+import "crypto/tls"
+import "crypto/x509"
+import "crypto/x509/pkix"
+
+// PeerIdentity of remote, obtained from its verified TLS certificate.
+type PeerIdentity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+	// Certificate is the verified leaf certificate presented by remote.
+	Certificate *x509.Certificate
+}
+
+func newPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	return &PeerIdentity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+}
+
+// TLSDialer return a DialFunc to dial remote at addr over tls.
+func TLSDialer(addr string, config *tls.Config) DialFunc {
+	return func() (Transporter, error) {
+		return tls.Dial("tcp", addr, config)
+	}
+}
+
+// ServeTLS is same as Serve, but every accepted connection is wrapped
+// as a tls server connection using config. Set config.ClientAuth to
+// tls.RequireAndVerifyClientCert for mutual-TLS.
+func (srv *Server) ServeTLS(lis net.Listener, config *tls.Config) error {
+	return srv.Serve(tls.NewListener(lis, config))
+}
+
+// PeerIdentity return remote's identity from its verified certificate.
+// Return nil if connection is not TLS, or if remote did not present a
+// verified certificate. Available after Handshake.
+func (t *Transport) PeerIdentity() *PeerIdentity {
+	return t.peerid
+}
+
+// tlshandshake shall complete the TLS handshake, if conn is a tls
+// connection, and remember remote's identity.
+func (t *Transport) tlshandshake() error {
+	tlsconn, ok := t.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if err := tlsconn.Handshake(); err != nil {
+		return err
+	}
+	state := tlsconn.ConnectionState()
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		t.peerid = newPeerIdentity(state.VerifiedChains[0][0])
+		verbosef("%v peer identity %v\n", t.logprefix, t.peerid.Subject)
+	}
+	return nil
+}
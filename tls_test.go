@@ -0,0 +1,178 @@
+package gofast
+
+import "testing"
+import "fmt"
+import "net"
+import "time"
+import "reflect"
+import "math/big"
+import "crypto/tls"
+import "crypto/rand"
+import "crypto/x509"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/x509/pkix"
+
+func TestMutualTLS(t *testing.T) {
+	ca, cakey := newTestCA()
+	srvcert := newTestCert(ca, cakey, "server", []string{"localhost"})
+	clicert := newTestCert(ca, cakey, "client", []string{"client.gofast"})
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	addr := <-testBindAddrs
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idch := make(chan *PeerIdentity, 1)
+	mux := NewMux().SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			idch <- s.Transport().PeerIdentity()
+			s.Response(&testMessage{}, true)
+			return nil
+		})
+	ver := testVersion(1)
+	srv := &Server{
+		Settings: newsetts(TagOpaqueStart, TagOpaqueStart+10),
+		Version:  &ver,
+		Mux:      mux,
+	}
+	srvconfig := &tls.Config{
+		Certificates: []tls.Certificate{srvcert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	go srv.ServeTLS(lis, srvconfig)
+
+	cliconfig := &tls.Config{
+		Certificates: []tls.Certificate{clicert},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+	}
+	conn, err := TLSDialer(addr, cliconfig)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	transc, err := NewTransport("client", conn, &ver, setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	// client side identity of server.
+	if id := transc.PeerIdentity(); id == nil {
+		t.Errorf("expected peer identity")
+	} else if id.Subject.CommonName != "server" {
+		t.Errorf("expected %v, got %v", "server", id.Subject.CommonName)
+	} else if ref := []string{"localhost"}; !reflect.DeepEqual(id.DNSNames, ref) {
+		t.Errorf("expected %v, got %v", ref, id.DNSNames)
+	}
+	if wai, err := transc.Whoami(); err != nil {
+		t.Error(err)
+	} else if id := wai.Identity(); id == nil || id.Subject.CommonName != "server" {
+		t.Errorf("expected server identity, got %v", id)
+	}
+
+	// server side identity of client.
+	if err := transc.Request(&testMessage{1}, true, &testMessage{}); err != nil {
+		t.Error(err)
+	}
+	select {
+	case id := <-idch:
+		if id == nil {
+			t.Errorf("expected peer identity")
+		} else if id.Subject.CommonName != "client" {
+			t.Errorf("expected %v, got %v", "client", id.Subject.CommonName)
+		} else if ref := []string{"client.gofast"}; !reflect.DeepEqual(id.DNSNames, ref) {
+			t.Errorf("expected %v, got %v", ref, id.DNSNames)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected request on server")
+	}
+
+	transc.Close()
+	srv.Close()
+}
+
+func TestPlainIdentity(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+
+	if id := transc.PeerIdentity(); id != nil {
+		t.Errorf("unexpected identity %v", id)
+	} else if wai, err := transc.Whoami(); err != nil {
+		t.Error(err)
+	} else if id := wai.Identity(); id != nil {
+		t.Errorf("unexpected identity %v", id)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+var testSerial = int64(1)
+
+func newTestCA() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: "gofast-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return ca, key
+}
+
+func newTestCert(
+	ca *x509.Certificate, cakey *ecdsa.PrivateKey,
+	cn string, dnsnames []string) tls.Certificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsnames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, cakey)
+	if err != nil {
+		panic(fmt.Errorf("create certificate: %v", err))
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
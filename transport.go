@@ -2,8 +2,10 @@ package gofast
 
 import "fmt"
 import "net"
+import "context"
 import "time"
 import "sort"
+import "reflect"
 import "sync"
 import "strings"
 import "unsafe"
@@ -11,10 +13,14 @@ import "sync/atomic"
 
 import s "github.com/bnclabs/gosettings"
 
-type tagfn func(in, out []byte) int
-type fnTagFactory func(*Transport, s.Settings) (uint64, tagfn, tagfn)
+// tagcodec is an element in the tag pipeline, pipeline is applied in
+// the order advertised by the receiving end, and unwrapped in reverse.
+type tagcodec struct {
+	tag       uint64
+	fn        TagFn
+	integrity bool
+}
 
-var tagFactory = make(map[string]fnTagFactory)
 var transports = unsafe.Pointer(&map[string]*Transporter{})
 
 /*
@@ -37,6 +43,12 @@ type RequestCallback func(*Stream, BinMessage) StreamCallback
 
 // StreamCallback handler called for an incoming message on a stream,
 // the boolean argument, if false, indicates whether remote has closed.
+// If remote has cancelled the request or stream, callback is dispatched
+// with ok as false and BinMessage.IsCancel() as true.
+//
+// If a RequestCallback returns a StreamCallback for an incoming request,
+// it shall be dispatched only if remote cancels the request before it is
+// responded.
 type StreamCallback func(BinMessage, bool)
 
 // Transporter interface to send and receive packets, connection object
@@ -49,6 +61,13 @@ type Transporter interface { // facilitates unit testing
 	Close() error
 }
 
+// BuffersWriter can be implemented by Transporter to write a batch of
+// packets with a single vectored write, like writev(2). Connections of
+// type *net.TCPConn are handled natively.
+type BuffersWriter interface {
+	WriteBuffers(bufs *net.Buffers) (n int64, err error)
+}
+
 // Transport is a peer-to-peer transport enabler.
 type Transport struct {
 	// statistics, keep this 8-byte aligned.
@@ -61,6 +80,7 @@ type Transport struct {
 	nTxstart  uint64 // number of start messages transmitted
 	nTxstream uint64 // number of stream messages transmitted
 	nTxfin    uint64 // number of finish messages transmitted
+	nTxcancel uint64 // number of cancel messages transmitted
 	nRx       uint64 // number of packets received
 	nRxbyte   uint64 // number of bytes received from socket
 	nRxpost   uint64 // number of post messages received
@@ -69,41 +89,97 @@ type Transport struct {
 	nRxstart  uint64 // number of start messages received
 	nRxstream uint64 // number of stream messages received
 	nRxfin    uint64 // number of finish messages received
+	nRxcancel uint64 // number of cancel messages received
 	nRxbeats  uint64 // number of heartbeats received
 	nDropped  uint64 // number of dropped bytes
 	nMdrops   uint64 // number of dropped messages
+	nAuthfail uint64 // number of failed authentication from remote
+	nCorrupt  uint64 // number of packets failing integrity check
+	nTxfrag   uint64 // number of continuation frames transmitted
+	nRxfrag   uint64 // number of continuation frames received
+
+	nTxlanes [nlanes]uint64 // number of packets transmitted per lane
 
 	// 0 no handshake
 	// 1 oneway handshake
 	// 2 bidirectional handshake
 	xchngok int64
+	// stream window advertised by remote, ZERO means no flow control.
+	peerwindow int64
+	// number of remote requests and streams yet to be completed.
+	nactive int64
+	// number of local requests waiting for response.
+	nflight int64
+	// nanoseconds a batched packet may wait before doTx flushes the batch.
+	linger int64
+	// bitmask of tags in tagdec, required on every packet from remote,
+	// configured tags.required and negotiated integrity tags.
+	tagreq uint64
+	// 1 if GOAWAY is sent to remote.
+	txgoaway uint32
+	// 1 if tag negotiation with remote failed.
+	tagmismatch uint32
+	// 1 if negotiated tags are stateful, packets shall be encoded and
+	// transmitted in the same order.
+	txordered uint32
 
 	// fields.
-	name     string
-	version  Version
-	peerver  atomic.Value
-	tagenc   map[uint64]tagfn   // tagid -> func
-	tagdec   map[uint64]tagfn   // tagid -> func
-	messages map[uint64]Message // msgid -> message
-	handlers map[uint64]RequestCallback
-	defaulth RequestCallback
-	conn     Transporter
-	aliveat  int64
-	txch     chan *txproto
-	rxch     chan rxpacket
-	killch   chan struct{}
+	name    string
+	version Version
+	peerver atomic.Value
+	peerid  *PeerIdentity // from tls, set before handshake.
+	salt    []byte        // random, advertised to remote in whoami.
+	// []byte, remote's salt from whoami, set when tags are negotiated.
+	peersalt atomic.Value
+	// authentication, if configured.
+	auth          Authenticator
+	authok        uint32             // 1 if remote is verified.
+	authchallenge atomic.Value       // []byte challenge sent to remote.
+	rxgoaway      atomic.Value       // *goawayMsg from remote
+	tagenc        atomic.Value       // []tagcodec, as advertised by remote
+	tagdec        []tagcodec         // ordered as advertised to remote
+	txmu          sync.Mutex         // serialize tx, for stateful tags
+	tagonce       sync.Once          // tag pipeline is settled only once
+	submu         sync.Mutex         // serialize subscriptions
+	messages      map[uint64]Message // msgid -> message
+	handlers      atomic.Value       // map[uint64]RequestCallback
+	defaulth      RequestCallback
+	conn          Transporter
+	aliveat       int64
+	txch          [nlanes]chan *txproto
+	lanes         map[uint64]Lane
+	rxch          chan rxpacket
+	killch        chan struct{}
 
 	// memory pools
 	pStrms  chan *Stream // for locally initiated streams
 	pTxcmd  chan *txproto
-	pData   chan []byte
+	bufs    *bufpool // size-class pools for packets and message data
 	pRxstrm *sync.Pool
+	pLease  *sync.Pool
+	nlocal  uint64 // number of local streams
+
+	// dispatcher routines, if configured.
+	workers   []chan rxjob
+	workerwg  sync.WaitGroup
+	handledch chan reqdone // requests handled by workers.
+	handled   []reqdone    // owned by syncRx, yet to be processed.
 
 	// settings
 	settings   s.Settings
 	buffersize uint64
+	fragsize   int64 // maximum message bytes in a single packet
+	maxmsgsize uint64
+	reassembly uint64 // maximum bytes being reassembled
+	leasedebug bool
+	leaseguard leaseguard
 	batchsize  uint64
 	chansize   uint64
+	reqtimeout time.Duration
+	window     uint64
+	winblock   bool
+	autogrant  bool
+	nworkers   uint64
 	logprefix  string
 }
 
@@ -115,37 +191,47 @@ func NewTransport(
 	name string, conn Transporter, version Version,
 	setts s.Settings) (*Transport, error) {
 
-	if setts == nil {
-		setts = DefaultSettings(1000, 5000)
-	}
+	// missing settings, or nil setts, shall default to DefaultSettings.
+	setts = DefaultSettings(1000, 5000).Mixin(setts)
 
 	buffersize := setts.Uint64("buffersize")
 	opqstart := setts.Uint64("opaque.start")
 	opqend := setts.Uint64("opaque.end")
 	chansize := setts.Uint64("chansize")
 	batchsize := setts.Uint64("batchsize")
+	reqtimeout := time.Duration(setts.Int64("request.timeout"))
 
 	t := &Transport{
-		name:    name,
-		version: version,
-		tagenc:  make(map[uint64]tagfn),
-		tagdec:  make(map[uint64]tagfn),
-		pStrms:  nil, // shall be initialized after setOpaqueRange() call
-		pTxcmd:  nil, // shall be initialized after setOpaqueRange() call
-		// TODO: avoid magic number
-		pData:    make(chan []byte, 1000),
+		name:     name,
+		version:  version,
+		tagdec:   []tagcodec{},
+		pStrms:   nil, // shall be initialized after setOpaqueRange() call
+		pTxcmd:   nil, // shall be initialized after setOpaqueRange() call
+		bufs:     newbufpool(int(buffersize)),
 		messages: make(map[uint64]Message),
-		handlers: make(map[uint64]RequestCallback),
+		lanes:    make(map[uint64]Lane),
 
 		conn:   conn,
-		txch:   make(chan *txproto, chansize+batchsize),
 		rxch:   make(chan rxpacket, chansize),
 		killch: make(chan struct{}),
 
 		settings:   setts,
 		batchsize:  batchsize,
 		buffersize: buffersize,
+		maxmsgsize: setts.Uint64("maxmessagesize"),
+		reassembly: setts.Uint64("reassembly.limit"),
+		leasedebug: setts.Bool("lease.debug"),
 		chansize:   chansize,
+		reqtimeout: reqtimeout * time.Millisecond,
+		window:     setts.Uint64("stream.window"),
+		winblock:   setts.Bool("stream.block"),
+		autogrant:  setts.Bool("stream.autogrant"),
+		nworkers:   setts.Uint64("dispatch.workers"),
+	}
+	t.linger = setts.Int64("batch.linger") * int64(time.Microsecond)
+	t.handlers.Store(make(map[uint64]RequestCallback))
+	for i := range t.txch {
+		t.txch[i] = make(chan *txproto, chansize+batchsize)
 	}
 	addtransport(name, t)
 
@@ -154,22 +240,34 @@ func NewTransport(
 	t.pRxstrm = &sync.Pool{
 		New: func() interface{} { return &Stream{} },
 	}
+	t.pLease = &sync.Pool{
+		New: func() interface{} { return &lease{} },
+	}
 
 	t.setOpaqueRange(uint64(opqstart), uint64(opqend))
 	t.subscribeMessage(&whoamiMsg{}, t.msghandler)
 	t.subscribeMessage(&pingMsg{}, t.msghandler)
 	t.subscribeMessage(&heartbeatMsg{}, t.msghandler)
+	t.subscribeMessage(&errorMsg{}, t.msghandler)
+	t.subscribeMessage(&goawayMsg{}, t.msghandler)
+	t.subscribeMessage(&authMsg{}, t.msghandler)
+	for _, msg := range []Message{
+		&whoamiMsg{}, &pingMsg{}, &heartbeatMsg{}, &goawayMsg{}, &authMsg{},
+	} {
+		t.SetLane(msg, LaneControl)
+	}
 
-	// educate transport with configured tag decoders.
-	tagcsv := setts.String("tags")
-	for _, tag := range t.getTags(tagcsv, []string{}) {
-		if factory, ok := tagFactory[tag]; ok {
-			tagid, _, dec := factory(t, setts)
-			t.tagdec[tagid] = dec
-			continue
-		}
-		errorf("%v unknown tag %v", t.logprefix, tag)
-		return nil, ErrorInvalidTag
+	var err error
+	if t.salt, err = newsalt(); err != nil {
+		deltransport(name)
+		return nil, err
+	} else if err = t.inittags(setts); err != nil {
+		deltransport(name)
+		return nil, err
+	}
+	if t.fragsize = fragsize(buffersize, len(t.tagdec)); t.fragsize <= 0 {
+		deltransport(name)
+		return nil, fmt.Errorf("buffersize %v too small for tags", buffersize)
 	}
 	verbosef("%v pre-initialized ...\n", t.logprefix)
 
@@ -181,7 +279,9 @@ func NewTransport(
 
 // SubscribeMessage that shall be exchanged via this transport. Only
 // subscribed messages can be exchanged. And for every incoming message
-// with its ID equal to msg.ID(), handler will be dispatch.
+// with its ID equal to msg.ID(), handler will be dispatch. Messages
+// can also be subscribed after Handshake(), messages received before
+// subscribing are handled by the default handler, if any.
 //
 // NOTE: handler shall not block and must be as light-weight as possible
 func (t *Transport) SubscribeMessage(msg Message, handler RequestCallback) *Transport {
@@ -208,32 +308,53 @@ func (t *Transport) DefaultHandler(handler RequestCallback) *Transport {
 // information will be gathered from romote:
 //   * Peer version, can later be queried via PeerVersion() API.
 //   * Tags settings.
+//   - Stream window, for flow control.
+//   - Peer identity, if connection is TLS, via PeerIdentity() API.
+//   - Authenticate with remote, if configured with an Authenticator.
 func (t *Transport) Handshake() error {
+	if err := t.tlshandshake(); err != nil {
+		return err
+	}
+
 	// now spawn the socket receiver, do this only after all messages
 	// are subscribed.
 	go t.syncRx() // shall spawn another go-routine doRx().
 
 	wai, err := t.Whoami()
-	if err != nil {
+	if err != nil && atomic.LoadUint32(&t.tagmismatch) == 1 {
+		return ErrTagMismatch // remote closed after failing to negotiate.
+	} else if err == ErrAuthFailed { // whoami tampered with.
+		t.Close()
+		return err
+	} else if err != nil {
 		return err
 	}
 
 	t.peerver.Store(wai.version)
+	atomic.StoreInt64(&t.peerwindow, int64(wai.window))
 
-	// parse tag list, tags shall be applied in the specified order.
-	for _, tag := range t.getTags(wai.tags, []string{}) {
-		if factory, ok := tagFactory[tag]; ok {
-			tagid, enc, _ := factory(t, t.settings)
-			t.tagenc[tagid] = enc
-			continue
-		}
-		warnf("%v remote ask for unknown tag: %v\n", t.logprefix, tag)
+	// settle on the tags supported by both sides.
+	if err := t.negotiate(&wai.whoamiMsg); err != nil {
+		t.Close()
+		return err
 	}
 	fmsg := "%v handshake completed with peer: %#v ...\n"
 	verbosef(fmsg, t.logprefix, wai)
 
+	if t.auth != nil { // prove our identity with remote.
+		if err := t.authenticate(); err != nil {
+			t.Close()
+			return err
+		}
+	}
+
 	atomic.AddInt64(&t.xchngok, 1)
 	for atomic.LoadInt64(&t.xchngok) < 2 { // wait till remote handshake
+		if t.IsClosed() && t.auth != nil {
+			return ErrAuthFailed
+		} else if t.IsClosed() {
+			return fmt.Errorf("transport closed")
+		}
 		time.Sleep(100 * time.Millisecond)
 	}
 
@@ -259,6 +380,43 @@ func (t *Transport) Close() error {
 	return t.conn.Close()
 }
 
+// Shutdown this transport gracefully, same as ShutdownReason() with
+// GoawayShutdown as the reason code.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	return t.ShutdownReason(ctx, GoawayShutdown, "shutdown")
+}
+
+// ShutdownReason shall post a GOAWAY to remote with reason code, there
+// after new posts, requests and streams from either side are rejected
+// with ErrGoaway, while outstanding requests and streams are allowed to
+// complete. Once they are complete, or when ctx is done, transport is
+// closed.
+func (t *Transport) ShutdownReason(
+	ctx context.Context, code uint64, reason string) error {
+
+	if atomic.CompareAndSwapUint32(&t.txgoaway, 0, 1) {
+		if err := t.Post(newGoaway(code, reason), true); err != nil {
+			warnf("%v goaway: %v\n", t.logprefix, err)
+		}
+		infof("%v going away %v:%v ...\n", t.logprefix, code, reason)
+	}
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for !t.drained() {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			t.Close()
+			return ctx.Err()
+		case <-t.killch:
+			return nil
+		}
+	}
+	t.tx([]byte{} /*empty*/, LaneBulk, true /*flush*/) // flush responses.
+	return t.Close()
+}
+
 // IsClosed return whether this transport is closed or not.
 func (t *Transport) IsClosed() bool {
 	select {
@@ -301,6 +459,15 @@ func (t *Transport) PeerVersion() Version {
 	return t.peerver.Load().(Version)
 }
 
+// PeerGoaway return the reason code and text, if remote has posted a
+// GOAWAY, ok shall be false otherwise.
+func (t *Transport) PeerGoaway() (code uint64, reason string, ok bool) {
+	if m, _ := t.rxgoaway.Load().(*goawayMsg); m != nil {
+		return m.code, m.reason, true
+	}
+	return 0, "", false
+}
+
 // Stat shall return the stat counts for this transport.
 // Refer gofast.Stat() api for more information.
 func (t *Transport) Stat() map[string]uint64 {
@@ -314,6 +481,7 @@ func (t *Transport) Stat() map[string]uint64 {
 		"n_txstart":  atomic.LoadUint64(&t.nTxstart),
 		"n_txstream": atomic.LoadUint64(&t.nTxstream),
 		"n_txfin":    atomic.LoadUint64(&t.nTxfin),
+		"n_txcancel": atomic.LoadUint64(&t.nTxcancel),
 		"n_rx":       atomic.LoadUint64(&t.nRx),
 		"n_rxbyte":   atomic.LoadUint64(&t.nRxbyte),
 		"n_rxpost":   atomic.LoadUint64(&t.nRxpost),
@@ -322,9 +490,18 @@ func (t *Transport) Stat() map[string]uint64 {
 		"n_rxstart":  atomic.LoadUint64(&t.nRxstart),
 		"n_rxstream": atomic.LoadUint64(&t.nRxstream),
 		"n_rxfin":    atomic.LoadUint64(&t.nRxfin),
+		"n_rxcancel": atomic.LoadUint64(&t.nRxcancel),
 		"n_rxbeats":  atomic.LoadUint64(&t.nRxbeats),
 		"n_dropped":  atomic.LoadUint64(&t.nDropped),
 		"n_mdrops":   atomic.LoadUint64(&t.nMdrops),
+		"n_authfail": atomic.LoadUint64(&t.nAuthfail),
+		"n_corrupt":  atomic.LoadUint64(&t.nCorrupt),
+		"n_txfrag":   atomic.LoadUint64(&t.nTxfrag),
+		"n_rxfrag":   atomic.LoadUint64(&t.nRxfrag),
+	}
+	for i := range t.nTxlanes {
+		key := "n_tx" + Lane(i).String()
+		stats[key] = atomic.LoadUint64(&t.nTxlanes[i])
 	}
 	return stats
 }
@@ -376,6 +553,9 @@ streams started by this local node.
 streams closed by this local node, should always match "n_txstart" plus
 active streams.
 
+"n_txcancel", number of cancel messages transmitted, indicates the number
+of requests and streams abandoned by this local node.
+
 "n_rx", number of packets received.
 
 "n_rxbyte", number of bytes received from socket.
@@ -395,11 +575,33 @@ streams started by the remote node.
 of streams closed by the remote node, should always match "n_rxstart"
 plus active streams.
 
+"n_rxcancel", number of cancel messages received, indicates the number of
+requests and streams abandoned by the remote node, including the cancel
+acknowledged by remote for requests and streams abandoned locally.
+
 "n_rxbeats", number of heartbeats received.
 
 "n_dropped", bytes dropped.
 
-"n_mdrops", messages dropped.
+"n_mdrops", messages dropped, including malformed whoami, goaway and
+auth messages from remote.
+
+"n_authfail", number of times remote failed to authenticate.
+
+"n_corrupt", packets dropped for failing the integrity check, like
+"crc32c" or "hmac" tags, or for failing decryption with "aesgcm" tag.
+Also counts fragmented messages dropped for continuation frames that
+do not match the message, or for exceeding "reassembly.limit".
+
+"n_txfrag", number of continuation frames transmitted, for messages
+larger than "buffersize".
+
+"n_rxfrag", number of continuation frames received, fragmented messages
+exceeding "maxmessagesize" are dropped and counted as "n_mdrops".
+
+"n_txcontrol", "n_txhigh", "n_txbulk", number of packets transmitted on
+control, high and bulk lanes. With stateful tags, like "gzipstream"
+and "aesgcm", all packets are transmitted on bulk lane.
 
 Note that `n_dropped` and `n_mdrops` are counted because gofast
 supports either end to finish an ongoing stream of messages.
@@ -421,11 +623,15 @@ func Stat(name string) map[string]uint64 {
 // Whoami shall return remote's Whoami.
 func (t *Transport) Whoami() (wai Whoami, err error) {
 	req, resp := newWhoami(t), newWhoami(t)
-	resp.transport = t
+	// decode peer's version into a new instance, not into local version.
+	typeOfVersion := reflect.ValueOf(t.version).Elem().Type()
+	resp.version = reflect.New(typeOfVersion).Interface().(Version)
 	if err = t.Request(req, true /*flush*/, resp); err != nil {
 		return
+	} else if err = resp.verify(req); err != nil {
+		return
 	}
-	return Whoami{whoamiMsg: *resp}, nil
+	return Whoami{whoamiMsg: *resp, identity: t.peerid}, nil
 }
 
 // Ping pong with peer, returns the pong string.
@@ -437,52 +643,158 @@ func (t *Transport) Ping(echo string) (string, error) {
 	return resp.echo, nil
 }
 
-// Post request to peer.
+// Post request to peer. If msg is larger than "maxmessagesize",
+// ErrMessageTooLarge is returned.
 func (t *Transport) Post(msg Message, flush bool) error {
-	stream := t.getlocalstream(false /*tellrx*/, nil)
-	defer t.putstream(stream.opaque, stream, false /*tellrx*/)
+	if err := t.goingaway(msg); err != nil {
+		return err
+	} else if err := t.oversized(msg); err != nil {
+		return err
+	}
+	ctx, lane := context.Background(), t.msglane(msg)
+	stream, err := t.getlocalstream(ctx, lane, false /*tellrx*/, nil)
+	if err != nil {
+		return err
+	}
+	// opaque is released after the last packet is written by doTx.
+	txlast := func(out []byte, lane Lane, flush bool) error {
+		return t.txrelease(out, lane, flush, stream)
+	}
 
-	n := t.post(msg, stream, stream.out)
-	return t.txasync(stream.out[:n], flush)
+	defer t.txunlock(t.txlock())
+	if err = t.txmsg(t.post, msg, stream, txlast, flush); err != nil {
+		t.putstream(stream.opaque, stream, false /*tellrx*/)
+	}
+	return err
 }
 
 // Request a response from peer. Caller is expected to pass reference to
 // an expected response message, this also implies that every request can
 // expect only one response type. This also have an added benefit of
-// reducing the memory pressure on GC.
+// reducing the memory pressure on GC. If "request.timeout" is configured,
+// request shall fail with context.DeadlineExceeded after that period.
+// If remote handler responds with Stream.ResponseError(), a *RemoteError
+// is returned and resp is left untouched. If msg is larger than
+// "maxmessagesize", ErrMessageTooLarge is returned.
 func (t *Transport) Request(msg Message, flush bool, resp Message) error {
-	donech := make(chan struct{})
-	stream := t.getlocalstream(true /*tellrx*/, func(bmsg BinMessage, ok bool) {
-		if resp != nil {
-			resp.Decode(bmsg.Data)
-		}
-		select {
-		case <-donech:
-		default:
-			close(donech)
+	ctx := context.Background()
+	if t.reqtimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.reqtimeout)
+		defer cancel()
+	}
+	return t.RequestContext(ctx, msg, flush, resp)
+}
+
+// RequestContext is same as Request, but shall give up waiting for the
+// response when ctx is done, returning ctx.Err(). Late response, if any,
+// from remote shall be dropped.
+func (t *Transport) RequestContext(
+	ctx context.Context, msg Message, flush bool, resp Message) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if err := t.goingaway(msg); err != nil {
+		return err
+	} else if err := t.oversized(msg); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&t.nflight, 1)
+	defer atomic.AddInt64(&t.nflight, -1)
+
+	var rxerr error // set by callback, before donech is closed.
+	var state int32 // 0 waiting, 1 responded, 2 cancelled.
+	donech, lane := make(chan struct{}), t.msglane(msg)
+	stream, err := t.getlocalstream(ctx, lane, true, func(
+		bmsg BinMessage, ok bool) {
+
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			return // cancelled, drop the response.
 		}
+		rxerr = rxresponse(bmsg, resp)
+		close(donech)
 	})
-	defer t.putstream(stream.opaque, stream, true /*tellrx*/)
-
-	n := t.request(msg, stream, stream.out)
-	if err := t.tx(stream.out[:n], flush); err != nil {
+	if err != nil {
 		return err
 	}
-	<-donech
+	gen := atomic.LoadUint64(&stream.gen)
+
+	locked := t.txlock()
+	txerr := t.txmsg(t.request, msg, stream, t.tx, flush)
+	t.txunlock(locked)
+	if txerr != nil {
+		t.dropstream(stream)
+		return txerr
+	}
+
+	select {
+	case <-donech:
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			t.cancelstream(stream, gen, true /*request*/)
+			return ctx.Err()
+		}
+		<-donech // response raced with cancellation.
+	}
 	stream.rxcallb = nil
-	return nil
+	t.putstream(stream.opaque, stream, true /*tellrx*/)
+	return rxerr
 }
 
 // Stream a bi-directional stream with peer.
 func (t *Transport) Stream(
 	msg Message, flush bool, rxcallb StreamCallback) (*Stream, error) {
 
-	stream := t.getlocalstream(true /*tellrx*/, rxcallb)
-	n := t.start(msg, stream, stream.out)
-	if err := t.tx(stream.out[:n], false); err != nil {
-		t.putstream(stream.opaque, stream, true /*tellrx*/)
+	return t.StreamContext(context.Background(), msg, flush, rxcallb)
+}
+
+// StreamContext is same as Stream, but the stream shall be closed when
+// ctx is done. On cancellation rxcallb is called with false, remote is
+// sent a stream-finish and, subsequent calls to Stream.Stream() shall
+// return ctx.Err(). Remote messages arriving after cancellation are
+// dropped.
+func (t *Transport) StreamContext(
+	ctx context.Context, msg Message, flush bool,
+	rxcallb StreamCallback) (*Stream, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if err := t.goingaway(msg); err != nil {
+		return nil, err
+	} else if err := t.oversized(msg); err != nil {
+		return nil, err
+	}
+
+	if rxcallb == nil && atomic.LoadInt64(&t.peerwindow) > 0 {
+		// stream shall be live, to receive credits from remote.
+		rxcallb = func(BinMessage, bool) {}
+	}
+	lane := t.msglane(msg)
+	stream, err := t.getlocalstream(ctx, lane, true /*tellrx*/, rxcallb)
+	if err != nil {
 		return nil, err
 	}
+	locked := t.txlock()
+	err = t.txmsg(t.start, msg, stream, t.tx, false /*flush*/)
+	t.txunlock(locked)
+	if err != nil {
+		t.dropstream(stream)
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		gen, closech := atomic.LoadUint64(&stream.gen), make(chan struct{})
+		stream.ctx, stream.closech = ctx, closech
+		go func() {
+			select {
+			case <-ctx.Done():
+				t.cancelstream(stream, gen, false /*request*/)
+			case <-closech:
+			case <-t.killch:
+			}
+		}()
+	}
 	return stream, nil
 }
 
@@ -508,19 +820,175 @@ func (t *Transport) setOpaqueRange(start, end uint64) {
 			transport: t,
 			remote:    false,
 			opaque:    uint64(opaque),
-			out:       make([]byte, t.buffersize),
-			data:      make([]byte, t.buffersize),
-			tagout:    make([]byte, t.buffersize),
 		}
 		t.pStrms <- stream
+		t.nlocal++
 		fmsg := "%v ##%d(remote:%v) stream created ...\n"
 		verbosef(fmsg, t.logprefix, opaque, false)
 	}
 
 	t.pTxcmd = make(chan *txproto, end-start+1+uint64(t.batchsize))
 	for i := 0; i < cap(t.pTxcmd); i++ {
-		t.pTxcmd <- &txproto{packet: make([]byte, t.buffersize)}
+		t.pTxcmd <- &txproto{}
+	}
+}
+
+// goingaway shall return ErrGoaway if transport is shutting down, or
+// if remote has posted a GOAWAY. Reserved messages are always allowed.
+func (t *Transport) goingaway(msg Message) error {
+	if isReservedMsg(msg.ID()) {
+		return nil
+	} else if atomic.LoadUint32(&t.txgoaway) == 1 {
+		return ErrGoaway
+	} else if t.rxgoaway.Load() != nil {
+		return ErrGoaway
 	}
+	return nil
+}
+
+// drained shall return true if there are no outstanding requests and
+// streams, from either side.
+func (t *Transport) drained() bool {
+	if atomic.LoadInt64(&t.nactive) > 0 {
+		return false
+	}
+	return uint64(len(t.pStrms)) == t.nlocal
+}
+
+// outstanding shall return the number of local requests waiting for
+// response.
+func (t *Transport) outstanding() int64 {
+	return atomic.LoadInt64(&t.nflight)
+}
+
+// tagencoders return the tag pipeline negotiated with remote, nil
+// before handshake.
+func (t *Transport) tagencoders() []tagcodec {
+	codecs, _ := t.tagenc.Load().([]tagcodec)
+	return codecs
+}
+
+// tagindex search codecs, backwards from index `from`, for tag and
+// return its index, -1 if not found.
+func (t *Transport) tagindex(codecs []tagcodec, tag uint64, from int) int {
+	for i := from; i >= 0; i-- {
+		if codecs[i].tag == tag {
+			return i
+		}
+	}
+	return -1
+}
+
+// inittags educate transport with configured tag decoders, in the same
+// order as advertised to remote.
+func (t *Transport) inittags(setts s.Settings) error {
+	tagcsv := setts.String("tags")
+	for _, tag := range t.getTags(tagcsv, []string{}) {
+		if err := tagsettings(tag, setts); err != nil {
+			errorf("%v tag %v: %v\n", t.logprefix, tag, err)
+			return ErrorInvalidTag
+		}
+		if tagid, _, dec, ok := maketag(tag, t, setts); ok {
+			if t.tagindex(t.tagdec, tagid, len(t.tagdec)-1) >= 0 {
+				errorf("%v duplicate tag %v\n", t.logprefix, tag)
+				return ErrorInvalidTag
+			}
+			t.tagdec = append(t.tagdec, tagcodec{tag: tagid, fn: dec})
+			continue
+		}
+		errorf("%v unknown tag %v\n", t.logprefix, tag)
+		return ErrorInvalidTag
+	}
+	if len(t.tagdec) > 64 {
+		errorf("%v too many tags %v\n", t.logprefix, len(t.tagdec))
+		return ErrorInvalidTag
+	}
+	// required tags shall be a subset of configured tags.
+	for _, tag := range t.getTags(setts.String("tags.required"), []string{}) {
+		i := -1
+		if tagid, _, _, ok := maketag(tag, t, setts); ok {
+			i = t.tagindex(t.tagdec, tagid, len(t.tagdec)-1)
+		}
+		if i < 0 {
+			errorf("%v required tag %v not in tags\n", t.logprefix, tag)
+			return ErrorInvalidTag
+		}
+		t.tagreq |= 1 << uint(i)
+	}
+	return nil
+}
+
+// negotiate tag pipeline with remote's whoami, tags supported by both
+// sides are applied in the order advertised by remote. Return
+// ErrTagMismatch if a tag required by either side is not supported by
+// the other side. Integrity tags supported by both sides are implicitly
+// required on every packet from remote.
+func (t *Transport) negotiate(wai *whoamiMsg) error {
+	local := t.getTags(t.settings.String("tags"), []string{})
+	remote := t.getTags(wai.tags, []string{})
+	required := t.settings.String("tags.required")
+	for _, tag := range t.getTags(required, []string{}) {
+		if !hasString(tag, remote) {
+			errorf("%v remote does not support tag %v\n", t.logprefix, tag)
+			atomic.StoreUint32(&t.tagmismatch, 1)
+			return ErrTagMismatch
+		}
+	}
+	for _, tag := range t.getTags(wai.required, []string{}) {
+		if !hasString(tag, local) {
+			errorf("%v remote require unsupported tag %v\n", t.logprefix, tag)
+			atomic.StoreUint32(&t.tagmismatch, 1)
+			return ErrTagMismatch
+		}
+	}
+
+	t.tagonce.Do(func() { t.settletags(local, remote, wai.salt) })
+	return nil
+}
+
+// settletags shall build the tag pipeline from tags advertised by
+// remote, only once for remote's first whoami, as stateful encoders like
+// "aesgcm" and "gzipstream" shall not be reset.
+func (t *Transport) settletags(local, remote []string, peersalt []byte) {
+	t.peersalt.Store(append([]byte{}, peersalt...))
+
+	tagenc, ordered, tagreq := []tagcodec{}, uint32(0), uint64(0)
+	for _, tag := range remote {
+		if !hasString(tag, local) {
+			verbosef("%v skip tag %v, not supported\n", t.logprefix, tag)
+			continue
+		}
+		// local tags are already validated by NewTransport.
+		tagid, enc, _, _ := maketag(tag, t, t.settings)
+		entry := tagFactory[tag]
+		codec := tagcodec{tag: tagid, fn: enc, integrity: entry.integrity}
+		tagenc = append(tagenc, codec)
+		if entry.stateful {
+			ordered = 1
+		}
+		// remote shall apply negotiated integrity tags on every packet.
+		if i := t.tagindex(t.tagdec, tagid, len(t.tagdec)-1); entry.integrity {
+			tagreq |= 1 << uint(i)
+		}
+	}
+	atomic.StoreUint32(&t.txordered, ordered)
+	t.tagenc.Store(tagenc)
+	atomic.StoreUint64(&t.tagreq, atomic.LoadUint64(&t.tagreq)|tagreq)
+}
+
+// tagsettings validate settings required by keyed tags.
+func tagsettings(tag string, setts s.Settings) error {
+	switch tag {
+	case "hmac":
+		if setts.String("hmac.key") == "" {
+			return fmt.Errorf("hmac.key not configured")
+		}
+	case "aesgcm":
+		if _, _, err := aesgcmKeys(setts); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (t *Transport) getTags(line string, tags []string) []string {
@@ -532,17 +1000,27 @@ func (t *Transport) getTags(line string, tags []string) []string {
 	return tags
 }
 
+// subscribeMessage shall copy the handlers on write, so that messages
+// can be subscribed while syncRx and workers are dispatching handlers.
 func (t *Transport) subscribeMessage(m Message, h RequestCallback) *Transport {
 	id := m.ID()
+	t.submu.Lock()
+	handlers, _ := t.handlers.Load().(map[uint64]RequestCallback)
+	newhandlers := make(map[uint64]RequestCallback, len(handlers)+1)
+	for msgid, fn := range handlers {
+		newhandlers[msgid] = fn
+	}
+	newhandlers[id] = h
 	t.messages[id] = m
-	t.handlers[id] = h
+	t.handlers.Store(newhandlers)
+	t.submu.Unlock()
 	verbosef("%v subscribed %v\n", t.logprefix, m)
 	return t
 }
 
 func (t *Transport) requestCallback(s *Stream, msg BinMessage) StreamCallback {
-	id := msg.ID
-	if fn, ok := t.handlers[id]; ok && fn != nil {
+	id, handlers := msg.ID, t.handlers.Load().(map[uint64]RequestCallback)
+	if fn, ok := handlers[id]; ok && fn != nil {
 		return fn(s, msg)
 	} else if t.defaulth != nil {
 		return t.defaulth(s, msg)
@@ -554,42 +1032,22 @@ func (t *Transport) fromrxstrm() *Stream {
 	stream := t.pRxstrm.Get().(*Stream)
 	stream.transport, stream.rxcallb, stream.opaque = nil, nil, 0
 	stream.remote = false
-	if stream.out == nil {
-		stream.out = make([]byte, t.buffersize)
-	}
-	if stream.data == nil {
-		stream.data = make([]byte, t.buffersize)
-	}
-	if stream.tagout == nil {
-		stream.tagout = make([]byte, t.buffersize)
-	}
 	return stream
 }
 
 func (t *Transport) fromtxpool() *txproto {
 	arg := <-t.pTxcmd
-	arg.flush, arg.async = false, false
+	arg.flush, arg.async, arg.stream = false, false, nil
 	arg.n, arg.err, arg.respch = 0, nil, nil
 	return arg
 }
 
 func (t *Transport) getdata(size int) (data []byte) {
-	select {
-	case data = <-t.pData:
-		if cap(data) < size {
-			data = make([]byte, size)
-		}
-	default:
-		data = make([]byte, size)
-	}
-	return data[:size]
+	return t.bufs.get(size)
 }
 
 func (t *Transport) putdata(data []byte) {
-	select {
-	case t.pData <- data:
-	default: // let GC collect the data
-	}
+	t.bufs.put(data)
 }
 
 // add a new transport.
@@ -1,6 +1,8 @@
 package gofast
 
 import "testing"
+import "context"
+import "errors"
 import "reflect"
 import "fmt"
 import "syscall"
@@ -8,6 +10,7 @@ import "sort"
 import "net"
 import "time"
 import "sync"
+import "sync/atomic"
 import "strings"
 
 import s "github.com/bnclabs/gosettings"
@@ -29,8 +32,8 @@ func TestTransport(t *testing.T) {
 	// test
 	if ref := "server"; transv.Name() != ref {
 		t.Errorf("expected %v, got %v", ref, transv.Name())
-	} else if tags, ok := transc.tagenc[tagGzip]; !ok && len(transc.tagenc) != 1 {
-		t.Errorf("expected gzip, got %v, %v", tags, len(transc.tagenc))
+	} else if c := transc.tagencoders(); len(c) != 1 || c[0].tag != tagGzip {
+		t.Errorf("expected gzip, got %v", c)
 	} else if ref := "client"; ref != transc.Name() {
 		t.Errorf("expected %v, got %v", ref, transc.Name())
 	} else if !transc.PeerVersion().Equal(&ver) {
@@ -41,13 +44,13 @@ func TestTransport(t *testing.T) {
 		t.Errorf("unexpected cCounts: %v", cCounts)
 	} else if !verify(cCounts, "n_rxreq", "n_rxresp", 1) {
 		t.Errorf("unexpected cCounts: %v", cCounts)
-	} else if !verify(cCounts, "n_txresp", 1, "n_rxbyte", "n_txbyte", 90) {
+	} else if !verify(cCounts, "n_txresp", 1, "n_rxbyte", "n_txbyte", 148) {
 		t.Errorf("unexpected cCounts: %v", cCounts)
 	} else if !verify(sCounts, "n_flushes", "n_rx", "n_tx", 2) {
 		t.Errorf("unexpected sCounts: %v", sCounts)
 	} else if !verify(sCounts, "n_rxreq", "n_rxresp", 1) {
 		t.Errorf("unexpected cCounts: %v", cCounts)
-	} else if !verify(sCounts, "n_txresp", 1, "n_rxbyte", "n_txbyte", 90) {
+	} else if !verify(sCounts, "n_txresp", 1, "n_rxbyte", "n_txbyte", 148) {
 		t.Errorf("unexpected sCounts: %v", sCounts)
 	}
 
@@ -58,6 +61,34 @@ func TestTransport(t *testing.T) {
 	transv.Close()
 }
 
+func TestSettingsDefault(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	// settings missing from client's setts shall default.
+	setts := s.Settings{
+		"opaque.start": TagOpaqueStart + 11, "opaque.end": TagOpaqueStart + 20,
+	}
+	transc := newClientsetts("client", addr, setts)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	if ref, n := int64(512), transc.settings.Int64("buffersize"); n != ref {
+		t.Errorf("expected %v, got %v", ref, n)
+	} else if _, ok := setts["buffersize"]; ok {
+		t.Errorf("unexpected update to application settings")
+	}
+	if _, err := transc.Ping("settings"); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
 func TestSubscribeMessage(t *testing.T) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "") // init server
@@ -87,6 +118,50 @@ func TestSubscribeMessage(t *testing.T) {
 	transv.Close()
 }
 
+func TestBatchLinger(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["batchsize"], setts["batch.linger"] = 100, 1000
+	transc := newClientsetts("client", addr, setts)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+
+	// test
+	var posts int64
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			atomic.AddInt64(&posts, 1)
+			return nil
+		})
+	if ref, n := int64(time.Millisecond), transc.linger; n != ref {
+		t.Errorf("expected %v, got %v", ref, n)
+	}
+	// a partial batch is flushed once there is nothing more to send.
+	if err := transc.Post(&testMessage{1234}, false /*flush*/); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&posts); n != 1 {
+		t.Errorf("expected %v, got %v", 1, n)
+	}
+	oldest := time.Now().Add(-2 * time.Millisecond)
+	if !lingered(oldest, transc.linger) {
+		t.Errorf("expected batch to have lingered")
+	} else if lingered(time.Now(), transc.linger) {
+		t.Errorf("unexpected linger")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
 func TestFlushPeriod(t *testing.T) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "") // init server
@@ -518,6 +593,291 @@ func TestTransRequestOnebyte(t *testing.T) {
 	transv.Close()
 }
 
+func TestTransGo(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			if m.count == 0 {
+				s.ResponseError(errors.New("zero"), true)
+				return nil
+			}
+			s.Response(&m, true)
+			return nil
+		})
+	n := 100 // more than the opaque range.
+	done := make(chan *Call, n)
+	for i := 0; i < n; i++ {
+		transc.Go(&testMessage{uint64(i)}, &testMessage{}, done)
+	}
+	for i := 0; i < n; i++ {
+		call := <-done
+		req := call.Request.(*testMessage)
+		if req.count == 0 {
+			if _, ok := call.Error.(*RemoteError); !ok {
+				t.Errorf("expected *RemoteError, got %v", call.Error)
+			}
+		} else if call.Error != nil {
+			t.Error(call.Error)
+		} else if !reflect.DeepEqual(call.Response, req) {
+			t.Errorf("expected %v, got %v", req, call.Response)
+		}
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		transc.Go(&testMessage{1}, &testMessage{}, make(chan *Call))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTransGoContext(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+15)
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test, stalled handler shall not acknowledge cancels.
+	stallch := make(chan struct{})
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			<-stallch
+			return nil
+		})
+	done := make(chan *Call, 8)
+	for i := 0; i < 8; i++ {
+		tm := 100 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), tm)
+		start := time.Now()
+		msg := &testMessage{uint64(i)}
+		call := <-transc.GoContext(ctx, msg, &testMessage{}, done).Done
+		cancel()
+		if err := call.Error; err != context.DeadlineExceeded {
+			t.Errorf("%v expected %v, got %v", i, context.DeadlineExceeded, err)
+		} else if took := time.Since(start); took > time.Second {
+			t.Errorf("%v took %v", i, took)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	call := <-transc.GoContext(ctx, &testMessage{1}, nil, nil).Done
+	if call.Error != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, call.Error)
+	}
+	close(stallch)
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTransRequestError(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			s.ResponseError(&RemoteError{Code: 404, Text: "not found"}, true)
+			return nil
+		})
+	resp := &testMessage{}
+	err := transc.Request(&testMessage{1234}, true, resp)
+	if rerr, ok := err.(*RemoteError); !ok {
+		t.Errorf("expected *RemoteError, got %T", err)
+	} else if rerr.Code != 404 || rerr.Text != "not found" {
+		t.Errorf("unexpected %v", rerr)
+	} else if resp.count != 0 {
+		t.Errorf("unexpected response %v", resp)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTransRequestContext(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	msg := &testMessage{1234}
+	cancelch := make(chan bool, 2)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			if m.count == 1234 { // respond late
+				go func() {
+					time.Sleep(200 * time.Millisecond)
+					if err := s.Response(&m, true); err != ErrStreamCancelled {
+						t.Errorf("expected %v, got %v", ErrStreamCancelled, err)
+					}
+				}()
+				return func(rxmsg BinMessage, ok bool) {
+					cancelch <- rxmsg.IsCancel() && !ok
+				}
+			}
+			s.Response(&m, true)
+			return nil
+		})
+	resp := &testMessage{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err := transc.RequestContext(ctx, msg, true, resp)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	} else if resp.count != 0 {
+		t.Errorf("unexpected response %v", resp)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := transc.RequestContext(ctx, msg, true, resp); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	if ok := <-cancelch; !ok {
+		t.Errorf("expected cancel notification")
+	}
+	time.Sleep(300 * time.Millisecond) // late response shall be dropped.
+	cCounts, sCounts := transc.Stat(), transv.Stat()
+	if !verify(cCounts, "n_txcancel", "n_rxcancel", 1, "n_mdrops", 0) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	} else if !verify(sCounts, "n_txcancel", "n_rxcancel", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+	msg, resp = &testMessage{4321}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTransRequestTimeout(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["request.timeout"] = 50
+	transc := newClientsetts("client", addr, setts)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil // never respond
+		})
+	err := transc.Request(&testMessage{1234}, true, &testMessage{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	call := <-transc.Go(&testMessage{1234}, &testMessage{}, nil).Done
+	if call.Error != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, call.Error)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestTransOpaqueExhausted(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	setts := newsetts(TagOpaqueStart+11, TagOpaqueStart+15)
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test, stalled handler shall not acknowledge cancels.
+	stallch := make(chan struct{})
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			<-stallch
+			return nil
+		})
+	for i := 0; i < 8; i++ {
+		tm := 100 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), tm)
+		start := time.Now()
+		msg, resp := &testMessage{uint64(i)}, &testMessage{}
+		err := transc.RequestContext(ctx, msg, true, resp)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%v expected %v, got %v", i, context.DeadlineExceeded, err)
+		} else if took := time.Since(start); took > time.Second {
+			t.Errorf("%v took %v", i, took)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err := transc.StreamContext(ctx, &testMessage{10}, true, nil)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	close(stallch)
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
 func TestClientStream(t *testing.T) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "") // init server
@@ -758,6 +1118,497 @@ func TestServerStream(t *testing.T) {
 	transv.Close()
 }
 
+func TestStreamContext(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	finch := make(chan bool, 2)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(rxmsg BinMessage, ok bool) {
+				if !ok {
+					if err := s.Stream(&testMessage{1}, true); err != ErrStreamCancelled {
+						t.Errorf("expected %v, got %v", ErrStreamCancelled, err)
+					}
+					finch <- rxmsg.IsCancel()
+				}
+			}
+		})
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := transc.StreamContext(
+		ctx, &testMessage{1234}, true, func(rxmsg BinMessage, ok bool) {
+			if !ok {
+				finch <- false
+			}
+		})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = stream.Stream(&testMessage{1235}, true); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	if err = stream.Stream(&testMessage{1236}, true); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	} else if err = stream.Close(); err != nil {
+		t.Error(err)
+	}
+	if local, remote := <-finch, <-finch; local == remote {
+		t.Errorf("expected local and remote close, got %v %v", local, remote)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cCounts, sCounts := transc.Stat(), transv.Stat()
+	if !verify(cCounts, "n_txcancel", "n_rxcancel", 1, "n_txfin", 0) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	} else if !verify(sCounts, "n_txcancel", "n_rxcancel", 1, "n_rxfin", 0) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestStreamContextClose(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	finch := make(chan bool, 1)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(rxmsg BinMessage, ok bool) {
+				if !ok {
+					finch <- rxmsg.IsCancel()
+				}
+			}
+		})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := transc.StreamContext(
+		ctx, &testMessage{1234}, true, func(BinMessage, bool) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := atomic.LoadUint64(&stream.gen)
+	if err = stream.Close(); err != nil {
+		t.Error(err)
+	}
+	// context watcher racing with Close() shall not cancel the stream.
+	transc.cancelstream(stream, gen, false /*request*/)
+	if <-finch {
+		t.Errorf("expected finish, got cancel")
+	}
+	time.Sleep(100 * time.Millisecond)
+	cCounts, sCounts := transc.Stat(), transv.Stat()
+	if !verify(cCounts, "n_txcancel", "n_rxcancel", 0, "n_txfin", 1) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	} else if !verify(sCounts, "n_txcancel", "n_rxcancel", 0, "n_rxfin", 1) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestStreamWindow(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["stream.window"] = 4
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	if wai, err := transc.Whoami(); err != nil {
+		t.Fatal(err)
+	} else if wai.Window() != 4 {
+		t.Errorf("expected %v, got %v", 4, wai.Window())
+	}
+	count, finch := 0, make(chan int, 1)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(rxmsg BinMessage, ok bool) {
+				if ok {
+					count++
+					time.Sleep(time.Millisecond) // slow consumer
+					return
+				}
+				finch <- count
+			}
+		})
+	stream, err := transc.Stream(&testMessage{1234}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := stream.Stream(&testMessage{uint64(i)}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.Close()
+	if n := <-finch; n != 100 {
+		t.Errorf("expected %v, got %v", 100, n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cCounts, sCounts := transc.Stat(), transv.Stat()
+	if !verify(cCounts, "n_txstream", 100, "n_rxstream", 50) {
+		t.Errorf("unexpected cCounts %v", cCounts)
+	} else if !verify(sCounts, "n_rxstream", 100, "n_txstream", 50) {
+		t.Errorf("unexpected sCounts %v", sCounts)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestStreamGrant(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["stream.window"], setts["stream.autogrant"] = 4, false
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+	setts["stream.block"] = false
+	transc := newClientsetts("client", addr, setts)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	rxch := make(chan *Stream, 10)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return func(rxmsg BinMessage, ok bool) {
+				if ok {
+					rxch <- s
+				}
+			}
+		})
+	stream, err := transc.Stream(&testMessage{1234}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := stream.Stream(&testMessage{uint64(i)}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Stream(&testMessage{4}, true); err != ErrWindowExhausted {
+		t.Errorf("expected %v, got %v", ErrWindowExhausted, err)
+	}
+	var remote *Stream
+	for i := 0; i < 4; i++ {
+		remote = <-rxch
+	}
+	if err := remote.Grant(2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := stream.Stream(&testMessage{uint64(i)}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Stream(&testMessage{4}, true); err != ErrWindowExhausted {
+		t.Errorf("expected %v, got %v", ErrWindowExhausted, err)
+	}
+	stream.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestDispatchWorkers(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["dispatch.workers"] = 2
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	finch := make(chan uint64, 1)
+	transc.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			s.Response(&m, true)
+			return nil
+		})
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			if m.count == 0 { // stream
+				next := uint64(1)
+				return func(rxmsg BinMessage, ok bool) {
+					if !ok {
+						finch <- next
+						return
+					}
+					m.Decode(rxmsg.Data)
+					if m.count != next {
+						t.Errorf("expected %v, got %v", next, m.count)
+					}
+					next++
+				}
+			}
+			// request remote from within the handler.
+			resp := &testMessage{}
+			if err := transv.Request(&m, true, resp); err != nil {
+				t.Error(err)
+			}
+			s.Response(resp, true)
+			return nil
+		})
+
+	msg, resp := &testMessage{1234}, &testMessage{}
+	if err := transc.Request(msg, true, resp); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(resp, msg) {
+		t.Errorf("expected %v, got %v", msg, resp)
+	}
+
+	stream, err := transc.Stream(&testMessage{0}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 100; i++ {
+		if err := stream.Stream(&testMessage{i}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.Close()
+	if next := <-finch; next != 101 {
+		t.Errorf("expected %v, got %v", 101, next)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestDispatchWorkersLoad(t *testing.T) {
+	addr := <-testBindAddrs
+	setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+	setts["dispatch.workers"], setts["chansize"] = 1, 4
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = newsetts(TagOpaqueStart+1000, TagOpaqueStart+1400)
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&testMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			time.Sleep(1 * time.Millisecond)
+			s.Response(&m, true)
+			return nil
+		})
+
+	n, donech := 300, make(chan error, 300)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			msg, resp := &testMessage{uint64(i)}, &testMessage{}
+			donech <- transc.Request(msg, true, resp)
+		}(i)
+	}
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-donech:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-timeout:
+			t.Fatalf("completed only %v of %v requests", i, n)
+		}
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestShutdown(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			var m testMessage
+			m.Decode(rxmsg.Data)
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				s.Response(&m, true)
+			}()
+			return nil
+		})
+	msg := &testMessage{1234}
+	call := transc.Go(msg, &testMessage{}, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	donech := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		donech <- transv.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if code, reason, ok := transc.PeerGoaway(); !ok {
+		t.Errorf("expected goaway from remote")
+	} else if code != GoawayShutdown || reason != "shutdown" {
+		t.Errorf("unexpected goaway %v %v", code, reason)
+	} else if err := transc.Post(msg, true); err != ErrGoaway {
+		t.Errorf("expected %v, got %v", ErrGoaway, err)
+	} else if err := transv.Request(msg, true, nil); err != ErrGoaway {
+		t.Errorf("expected %v, got %v", ErrGoaway, err)
+	} else if transv.IsClosed() {
+		t.Errorf("expected transport to drain before closing")
+	}
+	// outstanding request shall complete.
+	if call = <-call.Done; call.Error != nil {
+		t.Error(call.Error)
+	} else if !reflect.DeepEqual(call.Response, msg) {
+		t.Errorf("expected %v, got %v", msg, call.Response)
+	}
+	if err := <-donech; err != nil {
+		t.Error(err)
+	} else if !transv.IsClosed() {
+		t.Errorf("expected transport to be closed")
+	}
+
+	lis.Close()
+	transc.Close()
+}
+
+func TestGoawayReject(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			t.Errorf("unexpected request after goaway")
+			return nil
+		})
+	// remote is yet to learn about the goaway.
+	atomic.StoreUint32(&transv.txgoaway, 1)
+
+	if err := transc.Request(&testMessage{1}, true, nil); err != ErrGoaway {
+		t.Errorf("expected %v, got %v", ErrGoaway, err)
+	}
+	cancelch := make(chan bool, 1)
+	stream, err := transc.Stream(
+		&testMessage{2}, true, func(rxmsg BinMessage, ok bool) {
+			cancelch <- rxmsg.IsCancel() && !ok
+		})
+	if err != nil {
+		t.Fatal(err)
+	} else if ok := <-cancelch; !ok {
+		t.Errorf("expected stream to be cancelled by remote")
+	} else if err := stream.Stream(&testMessage{3}, true); err != ErrStreamCancelled {
+		t.Errorf("expected %v, got %v", ErrStreamCancelled, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if x := atomic.LoadInt64(&transv.nactive); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	// test
+	transc.SubscribeMessage(&testMessage{}, nil)
+	transv.SubscribeMessage(
+		&testMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			return nil // never respond.
+		})
+	call := transc.Go(&testMessage{1234}, &testMessage{}, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := transv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	} else if !transv.IsClosed() {
+		t.Errorf("expected transport to be closed")
+	}
+	transc.Close()
+	if call = <-call.Done; call.Error == nil {
+		t.Errorf("expected error")
+	}
+
+	lis.Close()
+}
+
 func TestTransGzip(t *testing.T) {
 	addr := <-testBindAddrs
 	lis, serverch := newServer("server", addr, "gzip") // init server
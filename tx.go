@@ -4,72 +4,138 @@ import "fmt"
 import "sync/atomic"
 
 // | 0xd9 0xd9f7 | 0xc6 | packet |
-func (t *Transport) post(msg Message, stream *Stream, out []byte) (n int) {
-	atomic.AddUint64(&t.nTxpost, 1)
-	n = tag2cbor(tagCborPrefix, out)      // prefix
-	out[n] = 0xc6                         // 0xc6 (post, 0b100_00110 <tag,6>
-	n++                                   //
-	n += t.framepkt(msg, stream, out[n:]) // packet
-	return n
+func (t *Transport) post(
+	msg Message, stream *Stream, out []byte) (n int, err error) {
+
+	t.txcount(msg, &t.nTxpost)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0xc6                    // 0xc6 (post, 0b100_00110 <tag,6>
+	n++                              //
+	m, err := t.framepkt(msg, stream, out[n:])
+	return n + m, err
 }
 
 // | 0xd9 0xd9f7 | 0x81 | packet |
-func (t *Transport) request(msg Message, stream *Stream, out []byte) (n int) {
-	atomic.AddUint64(&t.nTxreq, 1)
-	n = tag2cbor(tagCborPrefix, out)      // prefix
-	out[n] = 0x81                         // 0x81 (request, 0b100_10001 <arr,1>)
-	n++                                   //
-	n += t.framepkt(msg, stream, out[n:]) // packet
-	return n
+func (t *Transport) request(
+	msg Message, stream *Stream, out []byte) (n int, err error) {
+
+	t.txcount(msg, &t.nTxreq)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0x81                    // 0x81 (request, 0b100_10001 <arr,1>)
+	n++                              //
+	m, err := t.framepkt(msg, stream, out[n:])
+	return n + m, err
 }
 
 // | 0xd9 0xd9f7 | 0x81 | packet |
-func (t *Transport) response(msg Message, stream *Stream, out []byte) (n int) {
-	atomic.AddUint64(&t.nTxresp, 1)
-	n = tag2cbor(tagCborPrefix, out)      // prefix
-	out[n] = 0x81                         // 0x81 (response, 0b100_10001 <arr,1>)
-	n++                                   //
-	n += t.framepkt(msg, stream, out[n:]) // packet
-	return n
+func (t *Transport) response(
+	msg Message, stream *Stream, out []byte) (n int, err error) {
+
+	t.txcount(msg, &t.nTxresp)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0x81                    // 0x81 (response, 0b100_10001 <arr,1>)
+	n++                              //
+	m, err := t.framepkt(msg, stream, out[n:])
+	return n + m, err
 }
 
 // | 0xd9 0xd9f7  | 0x9f | packet2    |
-func (t *Transport) start(msg Message, stream *Stream, out []byte) (n int) {
-	atomic.AddUint64(&t.nTxstart, 1)
-	n = tag2cbor(tagCborPrefix, out)      // prefix
-	n += arrayStart(out[n:])              // 0x9f (start stream as cbor array)
-	n += t.framepkt(msg, stream, out[n:]) // packet
-	return n
+func (t *Transport) start(
+	msg Message, stream *Stream, out []byte) (n int, err error) {
+
+	t.txcount(msg, &t.nTxstart)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	n += arrayStart(out[n:])         // 0x9f (start stream as cbor array)
+	m, err := t.framepkt(msg, stream, out[n:])
+	return n + m, err
 }
 
 // | 0xd9 0xd9f7  | 0xc7 | packet2    |
-func (t *Transport) stream(msg Message, stream *Stream, out []byte) (n int) {
-	atomic.AddUint64(&t.nTxstream, 1)
-	n = tag2cbor(tagCborPrefix, out)      // prefix
-	out[n] = 0xc7                         // 0xc7 (stream msg, 0b110_00111 <tag,7>)
-	n++                                   //
-	n += t.framepkt(msg, stream, out[n:]) // packet
-	return n
+func (t *Transport) stream(
+	msg Message, stream *Stream, out []byte) (n int, err error) {
+
+	t.txcount(msg, &t.nTxstream)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0xc7                    // 0xc7 (stream msg, 0b110_00111 <tag,7>)
+	n++                              //
+	m, err := t.framepkt(msg, stream, out[n:])
+	return n + m, err
 }
 
 // | 0xd9 0xd9f7  | 0xc8 | end-packet |
-func (t *Transport) finish(stream *Stream, out []byte) (n int) {
+func (t *Transport) finish(stream *Stream, out []byte) (n int, err error) {
 	atomic.AddUint64(&t.nTxfin, 1)
-	var scratch [16]byte
-	n = tag2cbor(tagCborPrefix, out)         // prefix
-	out[n] = 0xc8                            // 0xc8 (end stream, 0b110_01000 <tag,8>)
-	n++                                      //
-	m := tag2cbor(stream.opaque, scratch[:]) // tag-opaque
-	scratch[m] = 0x40                        // zero-len byte-string
-	m++
-	n += valbytes2cbor(scratch[:m], out[n:]) // packet
-	out[n] = 0xff                            // 0xff CBOR indefinite end.
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0xc8                    // 0xc8 (end stream, 0b110_01000 <tag,8>)
+	n++                              //
+	m, err := t.endpkt(stream, out[n:])
+	if err != nil {
+		return 0, err
+	}
+	n += m
+	out[n] = 0xff // 0xff CBOR indefinite end.
 	n++
-	return n
+	return n, nil
+}
+
+// | 0xd9 0xd9f7  | 0xc9 | end-packet |
+func (t *Transport) cancel(stream *Stream, out []byte) (n int, err error) {
+	atomic.AddUint64(&t.nTxcancel, 1)
+	n = tag2cbor(tagCborPrefix, out) // prefix
+	out[n] = 0xc9                    // 0xc9 (cancel, 0b110_01001 <tag,9>)
+	n++                              //
+	m, err := t.endpkt(stream, out[n:])
+	if err != nil {
+		return 0, err
+	}
+	n += m
+	out[n] = 0xff // 0xff CBOR indefinite end.
+	n++
+	return n, nil
+}
+
+// endpkt shall frame an end-packet for stream, negotiated integrity tags
+// are applied on an empty tagMsg so that remote can verify the packet,
+// else payload is a zero-len byte-string.
+//
+//	| tag-opaque | 0x40 |
+//	| tag-opaque | tag-integrity | ... | tagMsg |
+func (t *Transport) endpkt(stream *Stream, out []byte) (n int, err error) {
+	ping, pong := t.bufs.get(len(out)), t.bufs.get(len(out))
+	defer t.bufs.put(ping)
+	defer t.bufs.put(pong)
+
+	n = tag2cbor(tagMsg, ping)
+	tagged := false
+	for _, codec := range t.tagencoders() { // roll up integrity tags
+		if !codec.integrity {
+			continue
+		}
+		m := codec.fn(ping[:n], pong)
+		if m <= 0 {
+			return 0, ErrTagEncode
+		}
+		n = tag2cbor(codec.tag, ping)
+		n += valbytes2cbor(pong[:m], ping[n:])
+		tagged = true
+	}
+
+	m := tag2cbor(stream.opaque, pong) // finally roll up opaque
+	if tagged {
+		m += valbytes2cbor(ping[:n], pong[m:])
+	} else {
+		pong[m], m = 0x40, m+1 // zero-len byte-string
+	}
+	n = valbytes2cbor(pong[:m], out) // packet encoded as CBOR byte array
+	return n, nil
 }
 
-func (t *Transport) framepkt(msg Message, stream *Stream, ping []byte) (n int) {
-	data, pong := stream.data, stream.tagout
+func (t *Transport) framepkt(
+	msg Message, stream *Stream, ping []byte) (n int, err error) {
+
+	data, pong := t.bufs.get(len(ping)), t.bufs.get(len(ping))
+	defer t.bufs.put(data)
+	defer t.bufs.put(pong)
 
 	// tagMsg
 	n = tag2cbor(tagMsg, ping) // tagMsg
@@ -79,18 +145,25 @@ func (t *Transport) framepkt(msg Message, stream *Stream, ping []byte) (n int) {
 	n += tag2cbor(tagData, ping[n:])        // hdr-tagData
 	data = msg.Encode(data)                 // value
 	n += valbytes2cbor(data, ping[n:])
+	if frag, ok := msg.(*fragMsg); ok && frag.more() {
+		n += tag2cbor(tagMore, ping[n:])                      // hdr-tagMore
+		n += valuint642cbor(uint64(len(frag.data)), ping[n:]) // value
+	}
 	n += breakStop(ping[n:])
 
 	// NOTE: tagenc is updated as part of whoamiMsg message, due to
 	// which it needs to be skipped for whoamiMsg message during
-	// handshake, for now we skip tagenc for whoamiMsg all the time.
-	if _, ok := msg.(*whoamiMsg); !ok {
-		for tag, fn := range t.tagenc { // roll up tags
-			m := fn(ping[:n], pong)
-			if m == 0 { // skip tag
+	// handshake, for now we skip tagenc for whoamiMsg all the time,
+	// including its continuation frames.
+	if msg.ID() != msgWhoami {
+		for _, codec := range t.tagencoders() { // roll up tags, in order
+			m := codec.fn(ping[:n], pong)
+			if m == 0 && !codec.integrity { // skip tag
 				continue
+			} else if m <= 0 {
+				return 0, ErrTagEncode
 			}
-			n = tag2cbor(tag, ping)
+			n = tag2cbor(codec.tag, ping)
 			n += valbytes2cbor(pong[:m], ping[n:])
 		}
 	}
@@ -98,30 +171,64 @@ func (t *Transport) framepkt(msg Message, stream *Stream, ping []byte) (n int) {
 	m := tag2cbor(stream.opaque, pong) // finally roll up opaque
 	m += valbytes2cbor(ping[:n], pong[m:])
 	n = valbytes2cbor(pong[:m], ping) // packet encoded as CBOR byte array
-	return n
+	return n, nil
+}
+
+// txcount shall count a message once, continuation frames of a
+// fragmented message are counted as "n_txfrag".
+func (t *Transport) txcount(msg Message, stat *uint64) {
+	if frag, ok := msg.(*fragMsg); ok && frag.off > 0 {
+		atomic.AddUint64(&t.nTxfrag, 1)
+		return
+	}
+	atomic.AddUint64(stat, 1)
+}
+
+// txlock shall serialize framing and transmission of packets, if
+// negotiated tags are stateful, so that remote decodes them in the same
+// order they were encoded.
+func (t *Transport) txlock() bool {
+	if atomic.LoadUint32(&t.txordered) == 1 {
+		t.txmu.Lock()
+		return true
+	}
+	return false
+}
+
+func (t *Transport) txunlock(locked bool) {
+	if locked {
+		t.txmu.Unlock()
+	}
 }
 
 type txproto struct {
 	packet []byte // request
+	lane   Lane
 	flush  bool
 	async  bool
-	n      int // response
+	stream *Stream // released after packet is written, async only
+	n      int     // response
 	err    error
 	respch chan *txproto
 }
 
-func (t *Transport) tx(out []byte, flush bool) (err error) {
+func (t *Transport) tx(out []byte, lane Lane, flush bool) (err error) {
 	arg := t.fromtxpool()
+	recycle := true
 	defer func() {
-		arg.packet = arg.packet[:cap(arg.packet)]
-		t.pTxcmd <- arg
+		if recycle {
+			t.bufs.put(arg.packet)
+			arg.packet = nil
+			t.pTxcmd <- arg
+		}
 	}()
 
-	n := copy(arg.packet, out)
-	arg.packet, arg.flush, arg.async = arg.packet[:n], flush, false
+	arg.packet = t.bufs.get(len(out))
+	copy(arg.packet, out)
+	arg.lane, arg.flush, arg.async = t.txlane(lane), flush, false
 	arg.respch = make(chan *txproto, 1)
 	select {
-	case t.txch <- arg:
+	case t.txch[arg.lane] <- arg:
 		select {
 		case resp := <-arg.respch:
 			n, err := resp.n, resp.err
@@ -130,6 +237,7 @@ func (t *Transport) tx(out []byte, flush bool) (err error) {
 			}
 			return err // success or error
 		case <-t.killch:
+			recycle = false // doTx might still be holding arg.
 			return fmt.Errorf("transport closed")
 		}
 
@@ -138,14 +246,25 @@ func (t *Transport) tx(out []byte, flush bool) (err error) {
 	}
 }
 
-func (t *Transport) txasync(out []byte, flush bool) (err error) {
+func (t *Transport) txasync(out []byte, lane Lane, flush bool) (err error) {
+	return t.txrelease(out, lane, flush, nil)
+}
+
+// txrelease is same as txasync, additionally local stream, if not nil,
+// shall be returned to the pool after this packet is written, so that
+// its opaque is not reused, possibly on another lane, while its packets
+// are still queued.
+func (t *Transport) txrelease(
+	out []byte, lane Lane, flush bool, stream *Stream) (err error) {
+
 	arg := t.fromtxpool()
-	n := copy(arg.packet, out)
-	arg.packet = arg.packet[:n]
+	arg.packet = t.bufs.get(len(out))
+	copy(arg.packet, out)
 
-	arg.flush, arg.async = flush, true
+	arg.lane, arg.flush, arg.async = t.txlane(lane), flush, true
+	arg.stream = stream
 	select {
-	case t.txch <- arg:
+	case t.txch[arg.lane] <- arg:
 	case <-t.killch:
 		return fmt.Errorf("transport closed")
 	}
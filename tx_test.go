@@ -1,7 +1,14 @@
 package gofast
 
 import "testing"
+import "context"
 import "bytes"
+import "fmt"
+import "net"
+import "time"
+import "sync/atomic"
+
+import s "github.com/bnclabs/gosettings"
 
 func TestPost(t *testing.T) {
 	addr := <-testBindAddrs
@@ -13,15 +20,16 @@ func TestPost(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		217, 217, 247, 198, 88, 35, 217, 1, 22, 88, 30, 216, 43, 191,
-		216, 44, 25, 16, 2, 216, 45, 82,
+		217, 217, 247, 198, 88, 48, 217, 1, 22, 88, 43, 216, 43, 191,
+		216, 44, 25, 16, 2, 216, 45, 88, 30,
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.post(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.post(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -40,15 +48,16 @@ func TestRequest(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		217, 217, 247, 129, 88, 35, 217, 1, 22, 88, 30, 216, 43, 191,
-		216, 44, 25, 16, 2, 216, 45, 82,
+		217, 217, 247, 129, 88, 48, 217, 1, 22, 88, 43, 216, 43, 191,
+		216, 44, 25, 16, 2, 216, 45, 88, 30,
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.request(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.request(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -67,15 +76,16 @@ func TestResponse(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		217, 217, 247, 129, 88, 35, 217, 1, 22, 88, 30, 216, 43, 191,
-		216, 44, 25, 16, 2, 216, 45, 82,
+		217, 217, 247, 129, 88, 48, 217, 1, 22, 88, 43, 216, 43, 191,
+		216, 44, 25, 16, 2, 216, 45, 88, 30,
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.response(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.response(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -94,15 +104,16 @@ func TestStart(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		217, 217, 247, 159, 88, 35, 217, 1, 22, 88, 30, 216, 43, 191, 216,
-		44, 25, 16, 2, 216, 45, 82,
+		217, 217, 247, 159, 88, 48, 217, 1, 22, 88, 43, 216, 43, 191, 216,
+		44, 25, 16, 2, 216, 45, 88, 30,
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.start(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.start(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -121,15 +132,16 @@ func TestStream(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		217, 217, 247, 199, 88, 35, 217, 1, 22, 88, 30, 216, 43, 191, 216, 44,
-		25, 16, 2, 216, 45, 82,
+		217, 217, 247, 199, 88, 48, 217, 1, 22, 88, 43, 216, 43, 191, 216, 44,
+		25, 16, 2, 216, 45, 88, 30,
 		6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.stream(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.stream(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -148,9 +160,30 @@ func TestFinish(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{217, 217, 247, 200, 68, 217, 1, 22, 64, 255}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
+	out := make([]byte, 1024)
+	n, _ := transc.finish(stream, out)
+	if bytes.Compare(out[:n], ref) != 0 {
+		t.Errorf("expected %v, got %v", ref, out[:n])
+	}
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}
+
+func TestCancel(t *testing.T) {
+	addr := <-testBindAddrs
+	lis, serverch := newServer("server", addr, "") // init server
+	transc := newClient("client", addr, "")
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+
+	ref := []byte{217, 217, 247, 201, 68, 217, 1, 22, 64, 255}
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
-	n := transc.finish(stream, out)
+	n, _ := transc.cancel(stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -169,14 +202,15 @@ func TestFramePkt(t *testing.T) {
 	transv := <-serverch
 
 	ref := []byte{
-		88, 35, 217, 1, 22, 88, 30, 216, 43, 191, 216, 44, 25, 16, 2, 216, 45,
-		82, 6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
-		255,
+		88, 48, 217, 1, 22, 88, 43, 216, 43, 191, 216, 44, 25, 16, 2, 216, 45,
+		88, 30, 6, 99, 108, 105, 101, 110, 116, 1, 0, 0, 0, 0, 0, 0, 2, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255,
 	}
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	wai := newWhoami(transc)
-	n := transc.framepkt(wai, stream, out)
+	wai.salt = nil // salt is random.
+	n, _ := transc.framepkt(wai, stream, out)
 	if bytes.Compare(out[:n], ref) != 0 {
 		t.Errorf("expected %v, got %v", ref, out[:n])
 	}
@@ -194,7 +228,7 @@ func BenchmarkPostPkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -215,7 +249,7 @@ func BenchmarkRequestPkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -236,7 +270,7 @@ func BenchmarkResponsePkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -257,7 +291,7 @@ func BenchmarkStartPkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -278,7 +312,7 @@ func BenchmarkStreamPkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -299,7 +333,7 @@ func BenchmarkFinishPkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -320,7 +354,7 @@ func BenchmarkFramePkt(b *testing.B) {
 	}
 	transv := <-serverch
 
-	stream := transc.getlocalstream(false, nil)
+	stream, _ := transc.getlocalstream(context.Background(), LaneBulk, false, nil)
 	out := make([]byte, 1024)
 	msg := newPing("hello world")
 	b.ResetTimer()
@@ -331,3 +365,126 @@ func BenchmarkFramePkt(b *testing.B) {
 	transc.Close()
 	transv.Close()
 }
+
+type writevConn struct {
+	net.Conn
+	nwritev int64
+}
+
+func (conn *writevConn) WriteBuffers(bufs *net.Buffers) (int64, error) {
+	atomic.AddInt64(&conn.nwritev, 1)
+	return bufs.WriteTo(conn.Conn)
+}
+
+func TestWritev(t *testing.T) {
+	for i, writev := range []bool{true, false} {
+		ver := testVersion(1)
+		sconn, cconn := net.Pipe()
+		wconn := &writevConn{Conn: cconn}
+		setts := newsetts(TagOpaqueStart, TagOpaqueStart+10)
+		name := fmt.Sprintf("writev-server-%v", i)
+		transv, err := NewTransport(name, sconn, &ver, setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setts = newsetts(TagOpaqueStart+11, TagOpaqueStart+20)
+		setts["batchsize"], setts["tx.writev"] = 10, writev
+		name = fmt.Sprintf("writev-client-%v", i)
+		transc, err := NewTransport(name, wconn, &ver, setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rxch := make(chan int, 100)
+		transv.SubscribeMessage(
+			&blobMessage{},
+			func(s *Stream, rxmsg BinMessage) StreamCallback {
+				rxch <- len(rxmsg.Data)
+				return nil
+			})
+		transc.SubscribeMessage(&blobMessage{}, nil)
+		go transv.Handshake()
+		if err := transc.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 20; j++ {
+			if err := transc.Post(newblob(100+j), j == 19); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j := 0; j < 20; j++ {
+			select {
+			case n := <-rxch:
+				if n != 100+j {
+					t.Errorf("expected %v, got %v", 100+j, n)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for message %v", j)
+			}
+		}
+		if n := atomic.LoadInt64(&wconn.nwritev); writev && n == 0 {
+			t.Errorf("expected vectored writes")
+		} else if !writev && n != 0 {
+			t.Errorf("expected %v, got %v", 0, n)
+		}
+
+		transc.Close()
+		transv.Close()
+	}
+}
+
+func BenchmarkPostWritev(b *testing.B) {
+	benchmarkPost(b, true /*writev*/, 512)
+}
+
+func BenchmarkPostCopy(b *testing.B) {
+	benchmarkPost(b, false /*writev*/, 512)
+}
+
+func BenchmarkPostWritev8K(b *testing.B) {
+	benchmarkPost(b, true /*writev*/, 8192)
+}
+
+func BenchmarkPostCopy8K(b *testing.B) {
+	benchmarkPost(b, false /*writev*/, 8192)
+}
+
+func benchmarkPost(b *testing.B, writev bool, payload int) {
+	config := func(setts s.Settings) s.Settings {
+		setts["batchsize"], setts["buffersize"] = 100, payload+512
+		setts["tx.writev"] = writev
+		return setts
+	}
+	addr := <-testBindAddrs
+	setts := config(newsetts(TagOpaqueStart, TagOpaqueStart+10))
+	lis, serverch := newServersetts("server", addr, setts) // init server
+	setts = config(newsetts(TagOpaqueStart+11, TagOpaqueStart+20))
+	transc := newClientsetts("client", addr, setts)
+	transc.SubscribeMessage(&blobMessage{}, nil)
+	if err := transc.Handshake(); err != nil { // init client
+		panic(err)
+	}
+	transv := <-serverch
+	var nrx int64
+	transv.SubscribeMessage(
+		&blobMessage{},
+		func(s *Stream, rxmsg BinMessage) StreamCallback {
+			atomic.AddInt64(&nrx, 1)
+			return nil
+		})
+
+	msg := newblob(payload)
+	b.SetBytes(int64(len(msg.data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transc.Post(msg, i == b.N-1)
+	}
+	for atomic.LoadInt64(&nrx) < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+
+	lis.Close()
+	transc.Close()
+	transv.Close()
+}